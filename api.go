@@ -4,7 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,14 +21,59 @@ import (
 	_ "github.com/mweibel/gitreleases/statik"
 )
 
-const requestTimeout = 2 * time.Second
+const (
+	requestTimeout = 2 * time.Second
+	// batchTimeout limits the time spent resolving a batch of lookups.
+	batchTimeout = 10 * time.Second
+	// maxBatchLookups is the maximum number of lookups of a batch.
+	maxBatchLookups = 500
+)
 
 type apiServer struct {
-	server       *http.Server
-	router       *mux.Router
-	githubClient *GithubClient
-	logger       log.Logger
-	version      string
+	server      *http.Server
+	router      *mux.Router
+	proxyAccess proxyAccess
+	blobs       *BlobCache
+	github      *GithubClient
+	logger      log.Logger
+	version     string
+	// circuits are the providers guarding their APIs by circuit breakers keyed by their cache namespace.
+	circuits map[string]circuitReporter
+}
+
+// releaseHandler serves a request using the resolver of a release provider.
+type releaseHandler func(resolver *releaseResolver, w http.ResponseWriter, r *http.Request)
+
+// resolverLookup returns the resolver responsible for the request with the route variables `vars`,
+// nil if there is none.
+type resolverLookup func(vars map[string]string) *releaseResolver
+
+// fixedResolver always returns `resolver`.
+func fixedResolver(resolver *releaseResolver) resolverLookup {
+	return func(map[string]string) *releaseResolver {
+		return resolver
+	}
+}
+
+// hostResolver returns the resolver configured for the `host` route variable.
+func hostResolver(resolvers map[string]*releaseResolver) resolverLookup {
+	return func(vars map[string]string) *releaseResolver {
+		return resolvers[vars["host"]]
+	}
+}
+
+// withResolver binds `h` to the resolver responsible for the request. Requests for unknown hosts are rejected.
+func (as *apiServer) withResolver(lookup resolverLookup, h releaseHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolver := lookup(mux.Vars(r))
+		if resolver == nil {
+			reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
+			reqLogger.Info("unknown host", "vars", mux.Vars(r))
+			writeHTTPError(w, reqLogger, http.StatusNotFound, "Unknown host")
+			return
+		}
+		h(resolver, w, r)
+	})
 }
 
 // Start is starting the HTTP server.
@@ -35,8 +86,8 @@ func (as *apiServer) Shutdown(ctx context.Context) error {
 	return as.server.Shutdown(ctx)
 }
 
-// DownloadRelease fetches a release from GitHub according to parameters specified.
-func (as *apiServer) DownloadRelease(w http.ResponseWriter, r *http.Request) {
+// DownloadRelease fetches a release from the provider according to parameters specified.
+func (as *apiServer) DownloadRelease(resolver *releaseResolver, w http.ResponseWriter, r *http.Request) {
 	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
 	reqLogger.Info("fetching release URL")
 
@@ -44,43 +95,327 @@ func (as *apiServer) DownloadRelease(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	url, err := as.githubClient.FetchReleaseURL(ctx, vars["owner"], vars["repo"], vars["tag"], vars["assetName"])
-	if ctx.Err() != nil {
-		reqLogger.Error("error retrieving release URL", "err", err, "ctx error", ctx.Err())
-		writeHTTPError(w, reqLogger, http.StatusBadGateway, "Bad Gateway")
+	url, err := resolver.FetchReleaseURL(ctx, vars["owner"], vars["repo"], releaseTag(r, vars["tag"]), vars["assetName"])
+	if err != nil || ctx.Err() != nil {
+		as.writeFetchError(ctx, w, reqLogger, err, vars)
 		return
 	}
-	if err != nil {
-		switch t := err.(type) {
-		case GitHubError:
-			if t.Type == TypeNotFound {
-				reqLogger.Info("data not found", "err", t.WrappedError, "vars", vars)
-				writeHTTPError(w, reqLogger, http.StatusNotFound, t.WrappedError.Error())
-				return
-			} else {
-				reqLogger.Error("unhandled github error", "err", t.WrappedError, "vars", vars)
-				writeHTTPError(w, reqLogger, http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
+
+	reqLogger.Info("found release URL", "url", url)
+
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// DownloadPlatformRelease fetches the release asset built for the platform of the caller.
+// If no single asset can be selected, status code 300 is returned. The possible choices are only listed to callers
+// presenting the token of a `proxyAccess` rule covering the repository.
+func (as *apiServer) DownloadPlatformRelease(resolver *releaseResolver, w http.ResponseWriter, r *http.Request) {
+	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
+	reqLogger.Info("fetching platform release URL")
+
+	vars := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p := requestPlatform(r)
+	url, err := resolver.FetchPlatformReleaseURL(ctx, vars["owner"], vars["repo"], releaseTag(r, vars["tag"]), p)
+	if ambiguous, ok := err.(ambiguousAssetError); ok {
+		reqLogger.Info("no single asset for platform", "platform", p, "choices", len(ambiguous.Choices))
+		// the assets have been looked up using the server's credentials and might belong to a private repository,
+		// they are only listed to callers granted access to the repository like for proxied downloads.
+		if _, granted := as.proxyAccess.check(resolver.cacheKey(vars["owner"], vars["repo"]), requestToken(r)); !granted {
+			ambiguous.Choices = nil
 		}
-		reqLogger.Error("error retrieving release URL", "err", err, "vars", vars)
-		writeHTTPError(w, reqLogger, http.StatusInternalServerError, err.Error())
+		writeChoices(w, reqLogger, ambiguous)
+		return
+	}
+	if err != nil || ctx.Err() != nil {
+		as.writeFetchError(ctx, w, reqLogger, err, vars)
 		return
 	}
 
-	reqLogger.Info("found release URL", "url", url)
+	reqLogger.Info("found release URL", "url", url, "platform", p)
 
 	w.Header().Set("Location", url)
 	w.WriteHeader(http.StatusMovedPermanently)
 }
 
+// Checksum returns the SHA-256 digest of a release asset as listed in the checksum manifest of the release.
+// The digest is returned as plain text unless JSON is requested using `?format=json` or the `Accept` header.
+// Manifests are downloaded using the server's credentials, callers have to present a token of a `proxyAccess` rule
+// covering the repository.
+func (as *apiServer) Checksum(resolver *releaseResolver, w http.ResponseWriter, r *http.Request) {
+	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
+	reqLogger.Info("fetching checksum")
+
+	vars := mux.Vars(r)
+	if !as.authorize(resolver, w, r, reqLogger) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	c, err := resolver.FetchChecksum(ctx, vars["owner"], vars["repo"], releaseTag(r, vars["tag"]), vars["assetName"])
+	if err != nil || ctx.Err() != nil {
+		as.writeFetchError(ctx, w, reqLogger, err, vars)
+		return
+	}
+
+	reqLogger.Info("found checksum", "asset", c.Asset, "manifest", c.Manifest)
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(&c); err != nil {
+			reqLogger.Crit("error writing response", "err", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintln(w, c.SHA256); err != nil {
+		reqLogger.Crit("error writing response", "err", err)
+	}
+}
+
+// batchResponse is the result of a lookup of `ResolveBatch`, either `URL` or `Error` is set. `Status` is the status
+// code a single lookup would have been answered with.
+type batchResponse struct {
+	batchLookup
+	URL    string `json:"url,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ResolveBatch resolves a JSON list of lookups of GitHub release assets at once, see `GithubClient.ResolveBatch`.
+// The results are returned in the same order.
+func (as *apiServer) ResolveBatch(w http.ResponseWriter, r *http.Request) {
+	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
+
+	var lookups []batchLookup
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&lookups); err != nil {
+		writeHTTPError(w, reqLogger, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if len(lookups) == 0 || len(lookups) > maxBatchLookups {
+		writeHTTPError(w, reqLogger, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d lookups are required", maxBatchLookups))
+		return
+	}
+	for _, lookup := range lookups {
+		if lookup.Owner == "" || lookup.Repo == "" || lookup.Tag == "" || lookup.Asset == "" {
+			writeHTTPError(w, reqLogger, http.StatusBadRequest, "owner, repo, tag and asset are required")
+			return
+		}
+	}
+	reqLogger.Info("resolving batch", "lookups", len(lookups))
+
+	ctx, cancel := context.WithTimeout(r.Context(), batchTimeout)
+	defer cancel()
+
+	results := as.github.ResolveBatch(ctx, lookups)
+	out := make([]batchResponse, len(lookups))
+	for i, result := range results {
+		out[i] = batchResponse{batchLookup: lookups[i], URL: result.url, Status: http.StatusOK}
+		switch {
+		case result.err != nil && ctx.Err() == context.DeadlineExceeded:
+			out[i].Status, out[i].Error = http.StatusGatewayTimeout, "Gateway Timeout"
+		case result.err != nil:
+			out[i].Status, out[i].Error = fetchErrorStatus(result.err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&out); err != nil {
+		reqLogger.Crit("error writing response", "err", err)
+	}
+}
+
+// proxyResponseHeaders are the response headers of the download passed on to the caller of a proxied download.
+var proxyResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// ProxyRelease streams a release asset downloaded using the server's credentials, e.g. of a private repository.
+// Callers have to present a token of a `proxyAccess` rule covering the repository.
+func (as *apiServer) ProxyRelease(resolver *releaseResolver, w http.ResponseWriter, r *http.Request) {
+	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
+	reqLogger.Info("proxying release asset")
+
+	vars := mux.Vars(r)
+	if !as.authorize(resolver, w, r, reqLogger) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	asset, err := resolver.FetchProxyAsset(ctx, vars["owner"], vars["repo"], releaseTag(r, vars["tag"]), vars["assetName"])
+	if err != nil || ctx.Err() != nil {
+		as.writeFetchError(ctx, w, reqLogger, err, vars)
+		return
+	}
+
+	if as.blobs != nil {
+		file, err := as.blobs.Open(r.Context(), asset.Key, func(ctx context.Context) (*http.Response, error) {
+			return resolver.DownloadAsset(ctx, asset, nil)
+		})
+		if err == nil {
+			defer file.Close()
+			reqLogger.Info("serving cached release asset", "asset", asset.Name)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": asset.Name}))
+			http.ServeContent(w, r, asset.Name, time.Time{}, file)
+			return
+		}
+		if err != errBlobTooLarge {
+			as.writeFetchError(r.Context(), w, reqLogger, err, vars)
+			return
+		}
+		reqLogger.Info("asset exceeds blob cache size, streaming it", "asset", asset.Name)
+	}
+
+	// the download itself is not limited by `requestTimeout`, it is cancelled once the caller disconnects.
+	resp, err := resolver.DownloadAsset(r.Context(), asset, r.Header)
+	if err != nil {
+		as.writeFetchError(r.Context(), w, reqLogger, err, vars)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyHeaders(w.Header(), resp.Header, proxyResponseHeaders)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": asset.Name}))
+	w.WriteHeader(resp.StatusCode)
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		reqLogger.Info("proxied download aborted", "asset", asset.Name, "bytes", n, "err", err)
+		return
+	}
+	reqLogger.Info("proxied release asset", "asset", asset.Name, "status", resp.StatusCode, "bytes", n)
+}
+
+// authorize checks that the caller presents a token of a `proxyAccess` rule covering the repository of the request.
+// Otherwise the request is answered with 403 if no rule covers the repository or with 401, and false is returned.
+func (as *apiServer) authorize(resolver *releaseResolver, w http.ResponseWriter, r *http.Request, reqLogger log.Logger) bool {
+	vars := mux.Vars(r)
+	repository := resolver.cacheKey(vars["owner"], vars["repo"])
+	covered, granted := as.proxyAccess.check(repository, requestToken(r))
+	switch {
+	case granted:
+		return true
+	case !covered:
+		reqLogger.Info("proxy not enabled for repository", "repository", repository)
+		writeHTTPError(w, reqLogger, http.StatusForbidden, "Forbidden")
+	default:
+		reqLogger.Info("proxy access denied", "repository", repository)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gitreleases"`)
+		writeHTTPError(w, reqLogger, http.StatusUnauthorized, "Unauthorized")
+	}
+	return false
+}
+
+// SetBlobCache enables caching of proxied downloads on disk.
+func (as *apiServer) SetBlobCache(blobs *BlobCache) {
+	as.blobs = blobs
+}
+
+// retryAfterSeconds returns the value of the `Retry-After` header for a rate limit which resets at `resetAt`.
+func retryAfterSeconds(resetAt time.Time) int {
+	seconds := int(math.Ceil(time.Until(resetAt).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// releaseTag returns the requested tag. Prereleases are only considered for `latest` if opted in using `?prerelease=true`.
+func releaseTag(r *http.Request, tag string) string {
+	if prerelease, _ := strconv.ParseBool(r.URL.Query().Get("prerelease")); prerelease && tag == "latest" {
+		return "latest-any"
+	}
+	return tag
+}
+
+// fetchErrorStatus returns the HTTP status code and message answering a lookup which failed with `err`.
+func fetchErrorStatus(err error) (int, string) {
+	t, ok := err.(GitHubError)
+	if !ok {
+		return http.StatusInternalServerError, err.Error()
+	}
+	switch t.Type {
+	case TypeNotFound:
+		return http.StatusNotFound, t.WrappedError.Error()
+	case TypeRateLimited:
+		return http.StatusServiceUnavailable, "Service Unavailable"
+	case TypeForbidden:
+		return http.StatusForbidden, t.WrappedError.Error()
+	case TypeUnauthorized:
+		// the credentials of the server have been rejected, this is not the caller's fault.
+		return http.StatusBadGateway, "Bad Gateway: upstream credentials rejected"
+	case TypeTimeout:
+		return http.StatusGatewayTimeout, "Gateway Timeout"
+	}
+	return http.StatusBadGateway, "Bad Gateway"
+}
+
+// writeFetchError translates errors of the release providers into HTTP errors.
+func (as *apiServer) writeFetchError(ctx context.Context, w http.ResponseWriter, reqLogger log.Logger, err error, vars map[string]string) {
+	if ctx.Err() != nil {
+		reqLogger.Error("error retrieving release URL", "err", err, "ctx error", ctx.Err())
+		if ctx.Err() == context.DeadlineExceeded {
+			writeHTTPError(w, reqLogger, http.StatusGatewayTimeout, "Gateway Timeout")
+			return
+		}
+		writeHTTPError(w, reqLogger, http.StatusBadGateway, "Bad Gateway")
+		return
+	}
+	t, ok := err.(GitHubError)
+	switch {
+	case !ok:
+		reqLogger.Error("error retrieving release URL", "err", err, "vars", vars)
+	case t.Type == TypeNotFound:
+		reqLogger.Info("data not found", "err", t.WrappedError, "vars", vars)
+	case t.Type == TypeRateLimited:
+		reqLogger.Error("rate limit exhausted", "err", t.WrappedError, "vars", vars)
+		if limited, ok := t.WrappedError.(rateLimitedError); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limited.ResetAt)))
+		}
+	case t.Type == TypeForbidden:
+		reqLogger.Warn("access forbidden", "err", t.WrappedError, "vars", vars)
+	case t.Type == TypeUnauthorized:
+		reqLogger.Crit("upstream credentials rejected", "err", t.WrappedError, "vars", vars)
+	case t.Type == TypeTimeout:
+		reqLogger.Error("upstream timeout", "err", t.WrappedError, "vars", vars)
+	default:
+		reqLogger.Error("upstream error", "err", t.WrappedError, "vars", vars)
+	}
+	status, message := fetchErrorStatus(err)
+	writeHTTPError(w, reqLogger, status, message)
+}
+
+// circuitStates returns the state of the circuit breakers of all providers, sorted by the API they guard.
+func (as *apiServer) circuitStates() []circuitState {
+	states := []circuitState{}
+	for namespace, reporter := range as.circuits {
+		for api, breaker := range reporter.circuits() {
+			states = append(states, breaker.report(namespace+"/"+api))
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].API < states[j].API
+	})
+	return states
+}
+
 func (as *apiServer) Status(w http.ResponseWriter, r *http.Request) {
 	reqLogger := as.logger.New("method", r.Method, "url", r.RequestURI)
 
 	out := struct {
-		Version string `json:"version"`
+		Version  string         `json:"version"`
+		Circuits []circuitState `json:"circuits"`
 	}{
-		Version: version,
+		Version:  version,
+		Circuits: as.circuitStates(),
 	}
 	encoder := json.NewEncoder(w)
 
@@ -99,6 +434,32 @@ func writeHTTPError(w http.ResponseWriter, logger log.Logger, statusCode int, me
 	}
 }
 
+type choice struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func writeChoices(w http.ResponseWriter, logger log.Logger, e ambiguousAssetError) {
+	out := struct {
+		Message  string   `json:"message"`
+		Platform platform `json:"platform"`
+		Choices  []choice `json:"choices"`
+	}{
+		Message:  "Could not determine the asset for your platform, specify it using the `os` and `arch` query parameters.",
+		Platform: e.Platform,
+		Choices:  make([]choice, 0, len(e.Choices)),
+	}
+	for _, asset := range e.Choices {
+		out.Choices = append(out.Choices, choice{Name: asset.Name, URL: asset.DownloadUrl})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultipleChoices)
+	if err := json.NewEncoder(w).Encode(&out); err != nil {
+		logger.Crit("error writing response", "err", err)
+	}
+}
+
 func basicAuth(username, password string, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, pass, _ := r.BasicAuth()
@@ -113,25 +474,69 @@ func basicAuth(username, password string, h http.Handler) http.Handler {
 	})
 }
 
+// addCircuits records the circuit breakers of the provider of `resolver`, if it has any.
+func (as *apiServer) addCircuits(resolver *releaseResolver) {
+	if reporter, ok := resolver.provider.(circuitReporter); ok {
+		as.circuits[resolver.namespace] = reporter
+	}
+}
+
+// handleReleases registers the release routes below `prefix`, which contains the `owner` and `repo` variables.
+// The handler names used for the metrics are prefixed by `metricsPrefix`.
+func (as *apiServer) handleReleases(r *mux.Router, prefix, metricsPrefix string, lookup resolverLookup) {
+	r.Handle(prefix+"/{tag}/auto", addRequestMetrics(metricsPrefix+"DownloadPlatformRelease",
+		as.withResolver(lookup, as.DownloadPlatformRelease))).Methods(http.MethodGet)
+	r.Handle(prefix+"/{tag}/{assetName}/download", addRequestMetrics(metricsPrefix+"ProxyRelease",
+		as.withResolver(lookup, as.ProxyRelease))).Methods(http.MethodGet)
+	r.Handle(prefix+"/{tag}/{assetName}/sha256", addRequestMetrics(metricsPrefix+"Checksum",
+		as.withResolver(lookup, as.Checksum))).Methods(http.MethodGet)
+	r.Handle(prefix+"/{tag}/{assetName}", addRequestMetrics(metricsPrefix+"DownloadRelease",
+		as.withResolver(lookup, as.DownloadRelease))).Methods(http.MethodGet)
+}
+
 // NewAPIServer encapsulates the start of the gitreleases HTTP server.
-func NewAPIServer(addr, metricsUsername, metricsPassword, version string, client *GithubClient, logger log.Logger) *apiServer {
+//
+// `gitea` and `enterprise` contain the clients of the Gitea or Forgejo instances respectively the GitHub Enterprise
+// Servers keyed by their host, other hosts are rejected. Proxied downloads are only served to callers granted
+// `access`.
+func NewAPIServer(addr, metricsUsername, metricsPassword, version string, github *GithubClient, gitlab *GitlabClient, gitea map[string]*GiteaClient, enterprise map[string]*GithubClient, access proxyAccess, logger log.Logger) *apiServer {
 	r := mux.NewRouter()
 
 	as := apiServer{
 		server: &http.Server{
-			Addr:           addr,
-			Handler:        r,
-			ReadTimeout:    10 * time.Second,
-			WriteTimeout:   10 * time.Second,
+			Addr:        addr,
+			Handler:     r,
+			ReadTimeout: 10 * time.Second,
+			// there is no WriteTimeout as proxied downloads may take arbitrarily long. The lookups are limited
+			// by `requestTimeout`.
 			MaxHeaderBytes: 1 << 20,
 		},
-		githubClient: client,
-		logger:       logger,
-		version:      version,
+		proxyAccess: access,
+		github:      github,
+		logger:      logger,
+		version:     version,
+		circuits:    make(map[string]circuitReporter),
 	}
 
-	r.Handle("/gh/{owner}/{repo}/{tag}/{assetName}", addRequestMetrics("DownloadRelease",
-		http.HandlerFunc(as.DownloadRelease))).Methods(http.MethodGet)
+	as.addCircuits(github.releaseResolver)
+	as.addCircuits(gitlab.releaseResolver)
+	as.handleReleases(r, "/gh/{owner}/{repo}", "", fixedResolver(github.releaseResolver))
+	// GitLab namespaces may consist of nested groups, e.g. `/gl/group/subgroup/project/latest/tool.zip`.
+	as.handleReleases(r, "/gl/{owner:.+}/{repo}", "GitLab", fixedResolver(gitlab.releaseResolver))
+	giteaResolvers := make(map[string]*releaseResolver, len(gitea))
+	for host, client := range gitea {
+		giteaResolvers[host] = client.releaseResolver
+		as.addCircuits(client.releaseResolver)
+	}
+	as.handleReleases(r, "/gitea/{host}/{owner}/{repo}", "Gitea", hostResolver(giteaResolvers))
+	enterpriseResolvers := make(map[string]*releaseResolver, len(enterprise))
+	for host, client := range enterprise {
+		enterpriseResolvers[host] = client.releaseResolver
+		as.addCircuits(client.releaseResolver)
+	}
+	as.handleReleases(r, "/ghe/{host}/{owner}/{repo}", "GitHubEnterprise", hostResolver(enterpriseResolvers))
+	r.Handle("/api/v1/resolve", addRequestMetrics("ResolveBatch", http.HandlerFunc(as.ResolveBatch))).Methods(http.MethodPost)
+	circuitGauge.setSource(as.circuitStates)
 	r.Handle("/metrics", basicAuth(metricsUsername, metricsPassword, promhttp.Handler())).Methods(http.MethodGet)
 	r.HandleFunc("/status", as.Status).Methods(http.MethodGet)
 
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// assetPattern is the requested asset name. Besides a literal file name it may contain glob patterns
+// (see `path.Match`) as well as the `{tag}` and `{version}` placeholders, e.g. `tool_{version}_linux_*.tar.gz`.
+type assetPattern string
+
+// Reserved asset names which resolve to the source code archives GitHub provides for every release.
+const (
+	sourceTarball assetPattern = "source.tar.gz"
+	sourceZipball assetPattern = "source.zip"
+)
+
+// isLiteral returns true if the pattern is a plain file name without glob characters or placeholders.
+func (p assetPattern) isLiteral() bool {
+	return !strings.ContainsAny(string(p), `*?[{\`)
+}
+
+// nameFilter returns the value for the `name` argument of the `releaseAssets` connection.
+// Patterns are matched against the whole asset list of a release, therefore no filter is applied for them.
+func (p assetPattern) nameFilter() *githubv4.String {
+	if p.isLiteral() {
+		return githubv4.NewString(githubv4.String(p))
+	}
+	return nil
+}
+
+// expand replaces the placeholders using the tag name of a release.
+// `{version}` is the tag name without a leading `v`.
+func (p assetPattern) expand(tag string) string {
+	return strings.NewReplacer(
+		"{tag}", tag,
+		"{version}", strings.TrimPrefix(tag, "v"),
+	).Replace(string(p))
+}
+
+// sourceArchive returns the source code archive of the release for the reserved names.
+func (p assetPattern) sourceArchive(release releaseNode) releaseAssetNodes {
+	url := release.TarballUrl
+	if p == sourceZipball {
+		url = release.ZipballUrl
+	}
+	if url == "" {
+		return nil
+	}
+	return releaseAssetNodes{{Name: string(p), DownloadUrl: url}}
+}
+
+// match returns the assets of the release tagged `tag` which match the pattern.
+// If several assets match, they are sorted by name so that the first one is always the same.
+func (p assetPattern) match(tag string, release releaseNode) releaseAssetNodes {
+	if p == sourceTarball || p == sourceZipball {
+		return p.sourceArchive(release)
+	}
+
+	assets := release.ReleaseAssets.Nodes
+	if p.isLiteral() {
+		// already filtered by the API
+		return assets
+	}
+
+	expanded := p.expand(tag)
+	var matching releaseAssetNodes
+	for _, asset := range assets {
+		if ok, err := path.Match(expanded, asset.Name); err == nil && ok {
+			matching = append(matching, asset)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Name < matching[j].Name
+	})
+	return matching
+}
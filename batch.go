@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// batchSize is the number of lookups combined into a single GraphQL query.
+	batchSize = 50
+	// batchConcurrency is the number of lookups resolved concurrently which cannot be batched.
+	batchConcurrency = 8
+)
+
+// batchLookup is a single lookup of a batch, equivalent to `FetchReleaseURL`.
+type batchLookup struct {
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+	Tag   string `json:"tag"`
+	Asset string `json:"asset"`
+}
+
+// batchResult is the result of a batchLookup, either `url` or `err` is set.
+type batchResult struct {
+	url string
+	err error
+}
+
+// batchable returns the query resolving `lookup` as part of a batch, false if the lookup requires paging through
+// the releases. The latest release is only looked up in a batch if it is the one GitHub marks as "Latest".
+func batchable(lookup batchLookup) (batchQuery, bool) {
+	q := batchQuery{owner: lookup.Owner, repo: lookup.Repo, tag: lookup.Tag, pattern: assetPattern(lookup.Asset)}
+	if lookup.Tag == "latest" {
+		q.tag = ""
+		return q, true
+	}
+	if _, ok := latestChannels[lookup.Tag]; ok {
+		return q, false
+	}
+	if _, ok := versionConstraint(lookup.Tag); ok {
+		return q, false
+	}
+	return q, true
+}
+
+// ResolveBatch returns the download URLs of all `lookups`, in the same order. Cached lookups are answered from the
+// cache, the releases of the others are looked up using as few GraphQL queries as possible. Lookups which cannot be
+// answered by a batched query, e.g. version ranges or latest releases not containing the asset, are resolved one
+// by one using `FetchReleaseURL`.
+func (gh *GithubClient) ResolveBatch(ctx context.Context, lookups []batchLookup) []batchResult {
+	results := make([]batchResult, len(lookups))
+	// identical lookups are resolved once.
+	pending := make(map[string][]int)
+	var keys []string
+	for i, lookup := range lookups {
+		// the refresh runs after the loop moved on.
+		lookup := lookup
+		key := gh.cacheKey(lookup.Owner, lookup.Repo, lookup.Tag, lookup.Asset)
+		if url, stale, err := gh.cache.Get(key); url != "" || err != nil {
+			if stale {
+				gh.refresh(key, func(ctx context.Context) (string, error) {
+					return gh.fetchReleaseURL(ctx, lookup.Owner, lookup.Repo, lookup.Tag, lookup.Asset)
+				})
+			}
+			results[i] = batchResult{url: url, err: err}
+			continue
+		}
+		if _, ok := pending[key]; !ok {
+			keys = append(keys, key)
+		}
+		pending[key] = append(pending[key], i)
+	}
+
+	var single []string
+	var batch []string
+	var queries []batchQuery
+	for _, key := range keys {
+		q, ok := batchable(lookups[pending[key][0]])
+		if !ok || gh.mode == ModeREST {
+			single = append(single, key)
+			continue
+		}
+		batch = append(batch, key)
+		queries = append(queries, q)
+	}
+
+	resolve := func(key string, result batchResult) {
+		for _, i := range pending[key] {
+			results[i] = result
+		}
+	}
+	for start := 0; start < len(batch); start += batchSize {
+		end := start + batchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		single = append(single, gh.resolveBatch(ctx, batch[start:end], queries[start:end], resolve)...)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for _, key := range single {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			lookup := lookups[pending[key][0]]
+			url, err := gh.FetchReleaseURL(ctx, lookup.Owner, lookup.Repo, lookup.Tag, lookup.Asset)
+			resolve(key, batchResult{url: url, err: err})
+		}(key)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// resolveBatch resolves the lookups identified by their cache `keys` using a single batched query and caches their
+// results. The keys of lookups which have to be resolved one by one are returned, e.g. if the batched query failed.
+func (gh *GithubClient) resolveBatch(ctx context.Context, keys []string, queries []batchQuery, resolve func(key string, result batchResult)) []string {
+	if gh.graphqlBreaker.check() != nil || gh.graphql.exhausted() {
+		return keys
+	}
+	releases, errs, currLimit, err := gh.graphql.releasesBatch(ctx, queries)
+	gh.graphqlBreaker.observe(currLimit, err)
+	gh.logRateLimit("graphql", currLimit)
+	if err != nil {
+		gh.logger.Warn("batched graphql query failed, resolving lookups one by one", "err", err, "lookups", len(keys))
+		return keys
+	}
+
+	var single []string
+	for i, key := range keys {
+		release, pattern := releases[i], queries[i].pattern
+		var result batchResult
+		switch {
+		case errs[i] != nil:
+			result.err = errs[i]
+		case queries[i].tag == "":
+			var assets releaseAssetNodes
+			if release != nil && channelStable.contains(*release) {
+				assets = pattern.match(release.TagName, *release)
+			}
+			// the "Latest" release lacks the asset, the releases have to be paged through.
+			if len(assets) == 0 {
+				single = append(single, key)
+				continue
+			}
+			result.url = assets[0].DownloadUrl
+		case release == nil:
+			result.err = errReleaseNotFound
+		default:
+			if assets := pattern.match(queries[i].tag, *release); len(assets) > 0 {
+				result.url = assets[0].DownloadUrl
+			} else {
+				result.err = errAssetNotFound
+			}
+		}
+
+		gh.store(key, result.url, result.err)
+		resolve(key, result)
+	}
+	return single
+}
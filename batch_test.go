@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApiServer_ResolveBatch(t *testing.T) {
+	queries := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		body, _ := ioutil.ReadAll(r.Body)
+		fileName := "ok_asset_found_latest.json"
+		if strings.Contains(string(body), "r0: repository") {
+			fileName = "ok_batch.json"
+		}
+		file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.Copy(w, file)
+		if err != nil {
+			panic(err)
+		}
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	cache := NoopCache{}
+	gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+	gl := NewGitLabClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, nil, discardLogger())
+
+	lookups := `[
+		{"owner": "testing", "repo": "testing", "tag": "v1.0.0", "asset": "testing.zip"},
+		{"owner": "testing", "repo": "testing", "tag": "latest", "asset": "testing.zip"},
+		{"owner": "testing", "repo": "other", "tag": "v1.0.0", "asset": "testing.zip"},
+		{"owner": "testing", "repo": "testing", "tag": "v2.0.0", "asset": "testing.zip"},
+		{"owner": "testing", "repo": "testing", "tag": "v1.0.0", "asset": "testing.zip"},
+		{"owner": "testing", "repo": "testing", "tag": "latest", "asset": "other.zip"}
+	]`
+	expected := []struct {
+		URL    string
+		Status int
+		Error  string
+	}{
+		{URL: "https://example.com/testing/testing/releases/download/v1.0.0/testing.zip", Status: http.StatusOK},
+		{URL: "https://example.com/testing/testing/releases/download/v1.1.0/testing.zip", Status: http.StatusOK},
+		{Status: http.StatusNotFound, Error: "Could not resolve to a Repository with the name 'testing/other'."},
+		{Status: http.StatusNotFound, Error: errReleaseNotFound.Error()},
+		{URL: "https://example.com/testing/testing/releases/download/v1.0.0/testing.zip", Status: http.StatusOK},
+		// the "Latest" release lacks the asset, it is looked up on its own.
+		{URL: "https://example.com/testing/testing/releases/download/latest/testing.zip", Status: http.StatusOK},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/resolve", strings.NewReader(lookups))
+	w := httptest.NewRecorder()
+	as.server.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	var results []batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.URL != expected[i].URL || result.Status != expected[i].Status || result.Error != expected[i].Error {
+			t.Errorf("result %d does not match. Expected: %+v, got %+v", i, expected[i], result)
+		}
+	}
+	if queries != 2 {
+		t.Errorf("expected 2 queries, got %d", queries)
+	}
+}
+
+func TestApiServer_ResolveBatch_Malformed(t *testing.T) {
+	queries := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "r0: repository") {
+			// a 200 OK response without errors which cannot be decoded.
+			io.WriteString(w, `{"data": {"r0": {"release": 42}}}`)
+			return
+		}
+		file, err := os.Open(filepath.Join("test", "fixtures", "ok_asset_found_tag.json"))
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		io.Copy(w, file)
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	cache := NoopCache{}
+	gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+	gl := NewGitLabClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, nil, discardLogger())
+
+	lookups := `[{"owner": "testing", "repo": "testing", "tag": "v1.0.0", "asset": "testing.zip"}]`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/resolve", strings.NewReader(lookups))
+	w := httptest.NewRecorder()
+	as.server.Handler.ServeHTTP(w, r)
+
+	var results []batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	// the lookup is resolved on its own instead of being reported as not found.
+	expected := "https://example.com/testing/testing/releases/download/sometag/testing.zip"
+	if len(results) != 1 || results[0].URL != expected || results[0].Status != http.StatusOK {
+		t.Errorf("expected %s, got %+v", expected, results)
+	}
+	if queries != 2 {
+		t.Errorf("expected 2 queries, got %d", queries)
+	}
+}
+
+func TestApiServer_ResolveBatch_Invalid(t *testing.T) {
+	cache := NoopCache{}
+	gh := NewGitHubClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	gl := NewGitLabClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, nil, discardLogger())
+
+	for name, body := range map[string]string{
+		"invalid json":  `{`,
+		"empty":         `[]`,
+		"missing field": `[{"owner": "testing", "repo": "testing", "tag": "v1.0.0"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/resolve", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			as.server.Handler.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+// repoProvider resolves every release to an URL containing the repository and tag.
+type repoProvider struct{}
+
+func (repoProvider) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	return resolvedRelease{TagName: tag, Assets: releaseAssetNodes{{Name: string(pattern), DownloadUrl: "https://example.com/" + owner + "/" + repo + "/" + tag}}}, nil
+}
+
+func TestGithubClient_ResolveBatch_StaleRefresh(t *testing.T) {
+	cache := NewCache(10, 0, time.Hour, time.Hour)
+	defer cache.Close()
+	gh := NewGitHubClient("http://127.0.0.1:0", http.DefaultClient, cache, discardLogger())
+	gh.provider = repoProvider{}
+	gh.SetCacheTTLs(time.Hour, time.Minute, 0)
+
+	lookups := []batchLookup{
+		{Owner: "owner", Repo: "a", Tag: "v1", Asset: "tool.zip"},
+		{Owner: "owner", Repo: "b", Tag: "v1", Asset: "tool.zip"},
+	}
+	for _, lookup := range lookups {
+		cache.Put(gh.cacheKey(lookup.Owner, lookup.Repo, lookup.Tag, lookup.Asset), "https://example.com/stale", nil, time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	gh.ResolveBatch(context.Background(), lookups)
+	time.Sleep(50 * time.Millisecond)
+
+	// every stale entry is refreshed using its own lookup.
+	for _, lookup := range lookups {
+		expected := "https://example.com/owner/" + lookup.Repo + "/v1"
+		if url, _, _ := cache.Get(gh.cacheKey(lookup.Owner, lookup.Repo, lookup.Tag, lookup.Asset)); url != expected {
+			t.Errorf("%s: expected '%s', got '%s'", lookup.Repo, expected, url)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errBlobTooLarge is returned if an asset exceeds the size of the blob cache, it has to be streamed instead.
+var errBlobTooLarge = errors.New("blobcache: asset exceeds cache size")
+
+// BlobCache is a content-addressed on-disk cache of proxied assets with a size limit and LRU eviction.
+//
+// Blobs are stored as `blobs/<sha256>`, assets are mapped to their blob by files in `keys` named by the SHA-256 of
+// the asset's key. Identical assets share a blob. Files are written to `tmp` and renamed into place, readers never
+// see partial files. Concurrent requests of the same uncached asset are served by a single download.
+type BlobCache struct {
+	dir     string
+	maxSize int64
+
+	l         sync.Mutex
+	size      int64
+	blobs     map[string]*blobEntry
+	keys      map[string]string
+	lru       *list.List
+	downloads map[string]*blobDownload
+	// tooLarge holds the keys of assets exceeding the cache size, they are streamed without downloading them again.
+	tooLarge map[string]bool
+}
+
+type blobEntry struct {
+	digest string
+	size   int64
+	keys   map[string]bool
+	elem   *list.Element
+}
+
+// blobDownload is a download shared by all requests of the same asset. It is cancelled once no one waits for it.
+type blobDownload struct {
+	done    chan struct{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func (bc *BlobCache) blobPath(digest string) string {
+	return filepath.Join(bc.dir, "blobs", digest)
+}
+
+func (bc *BlobCache) keyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(bc.dir, "keys", hex.EncodeToString(sum[:]))
+}
+
+// keyFile is the content of a key file, the key itself is kept to rebuild the index.
+func keyFile(key, digest string) []byte {
+	return []byte(digest + "\n" + key)
+}
+
+// add records the blob `digest` for `key` as most recently used and evicts the least recently used blobs exceeding
+// the size limit. It has to be called with the lock held.
+func (bc *BlobCache) add(key, digest string, size int64) {
+	if previous, ok := bc.keys[key]; ok && previous != digest {
+		// the asset has been replaced, the previous blob is kept as long as other keys refer to it.
+		delete(bc.blobs[previous].keys, key)
+		if len(bc.blobs[previous].keys) == 0 {
+			bc.evict(bc.blobs[previous])
+		}
+	}
+	entry, ok := bc.blobs[digest]
+	if !ok {
+		entry = &blobEntry{digest: digest, size: size, keys: make(map[string]bool)}
+		entry.elem = bc.lru.PushFront(entry)
+		bc.blobs[digest] = entry
+		bc.size += size
+	}
+	bc.lru.MoveToFront(entry.elem)
+	entry.keys[key] = true
+	bc.keys[key] = digest
+
+	for bc.size > bc.maxSize && bc.lru.Len() > 1 {
+		bc.evict(bc.lru.Back().Value.(*blobEntry))
+	}
+}
+
+// evict removes the blob and all keys referring to it. It has to be called with the lock held.
+func (bc *BlobCache) evict(entry *blobEntry) {
+	for key := range entry.keys {
+		delete(bc.keys, key)
+		os.Remove(bc.keyPath(key))
+	}
+	os.Remove(bc.blobPath(entry.digest))
+	bc.lru.Remove(entry.elem)
+	delete(bc.blobs, entry.digest)
+	bc.size -= entry.size
+}
+
+// open returns the cached blob of `key`, nil if there is none or its file has been removed. The modification time
+// of the blob is updated to restore the LRU order after a restart. It has to be called with the lock held.
+func (bc *BlobCache) open(key string) (*os.File, error) {
+	digest, ok := bc.keys[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := bc.blobs[digest]
+	file, err := os.Open(bc.blobPath(digest))
+	if err != nil {
+		bc.evict(entry)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	bc.lru.MoveToFront(entry.elem)
+	now := time.Now()
+	os.Chtimes(bc.blobPath(digest), now, now)
+	return file, nil
+}
+
+// Open returns the cached asset `key`. If it is not cached yet, it is downloaded using `download` first.
+// Concurrent calls for the same key share a single download, which is cancelled once all of their contexts are done.
+//
+// The caller has to close the returned file. `errBlobTooLarge` is returned for assets exceeding the cache size.
+func (bc *BlobCache) Open(ctx context.Context, key string, download func(ctx context.Context) (*http.Response, error)) (*os.File, error) {
+	for {
+		bc.l.Lock()
+		if bc.tooLarge[key] {
+			bc.l.Unlock()
+			return nil, errBlobTooLarge
+		}
+		file, err := bc.open(key)
+		if file != nil || err != nil {
+			bc.l.Unlock()
+			return file, err
+		}
+		d, ok := bc.downloads[key]
+		if !ok {
+			downloadCtx, cancel := context.WithCancel(context.Background())
+			d = &blobDownload{done: make(chan struct{}), cancel: cancel}
+			bc.downloads[key] = d
+			go bc.fill(downloadCtx, key, download, d)
+		}
+		d.waiters++
+		bc.l.Unlock()
+
+		select {
+		case <-d.done:
+			if d.err != nil {
+				return nil, d.err
+			}
+		case <-ctx.Done():
+			bc.l.Lock()
+			d.waiters--
+			if d.waiters == 0 {
+				// later requests start a new download instead of joining the cancelled one.
+				if bc.downloads[key] == d {
+					delete(bc.downloads, key)
+				}
+				d.cancel()
+			}
+			bc.l.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fill downloads the asset `key` into the cache. The blob is moved to its content address while the lock is held,
+// it cannot be removed by a concurrent eviction of the same content before it is added to the index.
+func (bc *BlobCache) fill(ctx context.Context, key string, download func(ctx context.Context) (*http.Response, error), d *blobDownload) {
+	tmp, digest, size, err := bc.write(ctx, download)
+	if tmp != "" {
+		defer os.Remove(tmp)
+	}
+
+	bc.l.Lock()
+	if err == errBlobTooLarge {
+		bc.tooLarge[key] = true
+	}
+	if err == nil {
+		err = os.Rename(tmp, bc.blobPath(digest))
+	}
+	if err == nil {
+		err = ioutil.WriteFile(bc.keyPath(key)+".tmp", keyFile(key, digest), 0644)
+	}
+	if err == nil {
+		err = os.Rename(bc.keyPath(key)+".tmp", bc.keyPath(key))
+	}
+	if err == nil {
+		bc.add(key, digest, size)
+	}
+	if bc.downloads[key] == d {
+		delete(bc.downloads, key)
+	}
+	d.err = err
+	d.cancel()
+	close(d.done)
+	bc.l.Unlock()
+}
+
+// write downloads the asset into a temporary file and returns its path together with the digest and size of the
+// content. The temporary file is returned even if the download failed, the caller has to remove it.
+func (bc *BlobCache) write(ctx context.Context, download func(ctx context.Context) (*http.Response, error)) (string, string, int64, error) {
+	resp, err := download(ctx)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, NewGitHubError(fmt.Sprintf("blobcache: unexpected status code: %s", resp.Status), TypeUpstream)
+	}
+	if resp.ContentLength > bc.maxSize {
+		return "", "", 0, errBlobTooLarge
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Join(bc.dir, "tmp"), "blob")
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, bc.maxSize+1))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return tmp.Name(), "", 0, GitHubError{err, TypeUpstream}
+	}
+	if size > bc.maxSize {
+		return tmp.Name(), "", 0, errBlobTooLarge
+	}
+	return tmp.Name(), hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// load rebuilds the index from the files of a previous run. Blobs without keys and temporary files are removed.
+func (bc *BlobCache) load() error {
+	tmp := filepath.Join(bc.dir, "tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	for _, dir := range []string{tmp, filepath.Join(bc.dir, "blobs"), filepath.Join(bc.dir, "keys")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	keyFiles, err := ioutil.ReadDir(filepath.Join(bc.dir, "keys"))
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		key, digest string
+		blob        os.FileInfo
+	}
+	var entries []loaded
+	for _, info := range keyFiles {
+		keyPath := filepath.Join(bc.dir, "keys", info.Name())
+		content, err := ioutil.ReadFile(keyPath)
+		parts := strings.SplitN(string(content), "\n", 2)
+		if err != nil || len(parts) != 2 || bc.keyPath(parts[1]) != keyPath {
+			os.Remove(keyPath)
+			continue
+		}
+		blob, err := os.Stat(bc.blobPath(parts[0]))
+		if err != nil {
+			os.Remove(keyPath)
+			continue
+		}
+		entries = append(entries, loaded{key: parts[1], digest: parts[0], blob: blob})
+	}
+	// the least recently used blobs are added first.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].blob.ModTime().Before(entries[j].blob.ModTime())
+	})
+	for _, entry := range entries {
+		bc.add(entry.key, entry.digest, entry.blob.Size())
+	}
+
+	blobFiles, err := ioutil.ReadDir(filepath.Join(bc.dir, "blobs"))
+	if err != nil {
+		return err
+	}
+	for _, info := range blobFiles {
+		if _, ok := bc.blobs[info.Name()]; !ok {
+			os.Remove(bc.blobPath(info.Name()))
+		}
+	}
+	return nil
+}
+
+// NewBlobCache creates a BlobCache storing at most `maxSize` bytes in `dir`. Assets cached by a previous run
+// are kept.
+func NewBlobCache(dir string, maxSize int64) (*BlobCache, error) {
+	bc := BlobCache{
+		dir:       dir,
+		maxSize:   maxSize,
+		blobs:     make(map[string]*blobEntry),
+		keys:      make(map[string]string),
+		lru:       list.New(),
+		downloads: make(map[string]*blobDownload),
+		tooLarge:  make(map[string]bool),
+	}
+	if err := bc.load(); err != nil {
+		return nil, err
+	}
+	return &bc, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func blobDownloadOf(content string) func(ctx context.Context) (*http.Response, error) {
+	return func(ctx context.Context) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: int64(len(content)),
+			Body:          ioutil.NopCloser(strings.NewReader(content)),
+		}, nil
+	}
+}
+
+func readBlob(t *testing.T, bc *BlobCache, key string, download func(ctx context.Context) (*http.Response, error)) string {
+	file, err := bc.Open(context.Background(), key, download)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(content)
+}
+
+func tempBlobCache(t *testing.T, maxSize int64) (*BlobCache, string, func()) {
+	dir, err := ioutil.TempDir("", "blobcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlobCache(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc, dir, func() { os.RemoveAll(dir) }
+}
+
+func TestBlobCache_Dedupe(t *testing.T) {
+	bc, dir, teardown := tempBlobCache(t, 1024)
+	defer teardown()
+
+	var downloads int32
+	release := make(chan struct{})
+	download := func(ctx context.Context) (*http.Response, error) {
+		atomic.AddInt32(&downloads, 1)
+		<-release
+		return blobDownloadOf("content")(ctx)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if content := readBlob(t, bc, "gh/owner/repo/v1/tool.zip", download); content != "content" {
+				t.Errorf("unexpected content '%s'", content)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if downloads != 1 {
+		t.Errorf("expected a single download, got %d", downloads)
+	}
+
+	// identical assets share a blob.
+	if content := readBlob(t, bc, "gh/owner/repo/v2/tool.zip", blobDownloadOf("content")); content != "content" {
+		t.Errorf("unexpected content '%s'", content)
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	if len(blobs) != 1 {
+		t.Errorf("expected a single blob, got %d", len(blobs))
+	}
+}
+
+func TestBlobCache_Eviction(t *testing.T) {
+	bc, dir, teardown := tempBlobCache(t, 10)
+	defer teardown()
+
+	readBlob(t, bc, "a", blobDownloadOf("aaaa"))
+	readBlob(t, bc, "b", blobDownloadOf("bbbb"))
+	// `a` is used more recently than `b`, which is evicted for `c`.
+	readBlob(t, bc, "a", nil)
+	readBlob(t, bc, "c", blobDownloadOf("cccc"))
+
+	if _, ok := bc.keys["b"]; ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := bc.keys[key]; !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	// the index survives a restart.
+	reloaded, err := NewBlobCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := readBlob(t, reloaded, "c", nil); content != "cccc" {
+		t.Errorf("unexpected content '%s'", content)
+	}
+	if reloaded.size != 8 {
+		t.Errorf("expected size 8, got %d", reloaded.size)
+	}
+
+	if _, err := bc.Open(context.Background(), "large", blobDownloadOf("too large for the cache")); err != errBlobTooLarge {
+		t.Errorf("expected errBlobTooLarge, got %v", err)
+	}
+}
+
+func TestBlobCache_Cancel(t *testing.T) {
+	bc, _, teardown := tempBlobCache(t, 1024)
+	defer teardown()
+
+	cancelled := make(chan struct{})
+	download := func(ctx context.Context) (*http.Response, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, err := bc.Open(ctx, "key", download); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("expected the download to be cancelled")
+	}
+}
+
+func TestBlobCache_CancelRestart(t *testing.T) {
+	bc, _, teardown := tempBlobCache(t, 1024)
+	defer teardown()
+
+	// the cancelled download only returns once the second request arrived.
+	cancelled := make(chan struct{})
+	restarted := make(chan struct{})
+	download := func(ctx context.Context) (*http.Response, error) {
+		<-ctx.Done()
+		close(cancelled)
+		<-restarted
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, err := bc.Open(ctx, "key", download); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	<-cancelled
+
+	result := make(chan error)
+	go func() {
+		file, err := bc.Open(context.Background(), "key", blobDownloadOf("content"))
+		if err == nil {
+			file.Close()
+		}
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(restarted)
+	if err := <-result; err != nil {
+		t.Fatalf("expected a new download, got %v", err)
+	}
+	if content := readBlob(t, bc, "key", download); content != "content" {
+		t.Errorf("expected 'content', got '%s'", content)
+	}
+}
+
+func TestBlobCache_Removed(t *testing.T) {
+	bc, _, teardown := tempBlobCache(t, 1024)
+	defer teardown()
+
+	readBlob(t, bc, "key", blobDownloadOf("content"))
+	if err := os.Remove(bc.blobPath(bc.keys["key"])); err != nil {
+		t.Fatal(err)
+	}
+	// the removed blob is treated as a miss and downloaded again.
+	if content := readBlob(t, bc, "key", blobDownloadOf("content")); content != "content" {
+		t.Errorf("expected 'content', got '%s'", content)
+	}
+}
+
+func TestBlobCache_TooLarge(t *testing.T) {
+	bc, _, teardown := tempBlobCache(t, 10)
+	defer teardown()
+
+	downloads := 0
+	download := func(ctx context.Context) (*http.Response, error) {
+		downloads++
+		// the size is only known after reading the body.
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: -1,
+			Body:          ioutil.NopCloser(strings.NewReader("too large for the cache")),
+		}, nil
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := bc.Open(context.Background(), "large", download); err != errBlobTooLarge {
+			t.Errorf("expected errBlobTooLarge, got %v", err)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("expected a single download, got %d", downloads)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minRequestCost is the cost of the cheapest request, the circuit opens once fewer points are left. The cost reported
+// for a lookup is accumulated over all of its requests and says nothing about the cost of the next one.
+const minRequestCost = 1
+
+// circuitBreaker stops requests to an API whose rate limit is exhausted until the limit is reset. The circuit opens
+// if the API reports that fewer than `minRequestCost` points are left or if a request has been rejected as rate
+// limited.
+type circuitBreaker struct {
+	l         sync.RWMutex
+	openUntil time.Time
+}
+
+// circuitState is the state of a circuit breaker as reported on `/status` and in the metrics.
+type circuitState struct {
+	// API identifies the guarded API by the cache namespace of its provider, e.g. `gh/graphql`.
+	API     string     `json:"api"`
+	Open    bool       `json:"open"`
+	ResetAt *time.Time `json:"resetAt,omitempty"`
+}
+
+// circuitReporter is implemented by providers guarding their APIs using circuit breakers.
+type circuitReporter interface {
+	// circuits returns the circuit breakers keyed by the name of the API they guard.
+	circuits() map[string]*circuitBreaker
+}
+
+// observe updates the circuit using the rate limit and the error returned by a request.
+func (cb *circuitBreaker) observe(limit rateLimit, err error) {
+	var resetAt time.Time
+	if t, ok := err.(GitHubError); ok && t.Type == TypeRateLimited {
+		if limited, ok := t.WrappedError.(rateLimitedError); ok {
+			resetAt = limited.ResetAt
+		}
+	} else if limit.Limit > 0 && limit.Remaining < minRequestCost {
+		resetAt = limit.ResetAt
+	}
+	if resetAt.IsZero() {
+		return
+	}
+
+	cb.l.Lock()
+	if resetAt.After(cb.openUntil) {
+		cb.openUntil = resetAt
+	}
+	cb.l.Unlock()
+}
+
+// state returns whether the circuit is open and when it closes again.
+func (cb *circuitBreaker) state() (bool, time.Time) {
+	cb.l.RLock()
+	defer cb.l.RUnlock()
+	return time.Now().Before(cb.openUntil), cb.openUntil
+}
+
+// check returns a `TypeRateLimited` error while the circuit is open.
+func (cb *circuitBreaker) check() error {
+	if open, resetAt := cb.state(); open {
+		return GitHubError{rateLimitedError{ResetAt: resetAt}, TypeRateLimited}
+	}
+	return nil
+}
+
+// report returns the state of the circuit for the API `name`.
+func (cb *circuitBreaker) report(name string) circuitState {
+	open, resetAt := cb.state()
+	s := circuitState{API: name, Open: open}
+	if open {
+		s.ResetAt = &resetAt
+	}
+	return s
+}
+
+// breakerBackend guards a `releaseBackend` using a circuit breaker, no requests are sent while the circuit is open.
+type breakerBackend struct {
+	releaseBackend
+	breaker *circuitBreaker
+}
+
+func (bb breakerBackend) releaseByTag(ctx context.Context, owner, repo, tag string, pattern assetPattern) (*releaseNode, rateLimit, error) {
+	if err := bb.breaker.check(); err != nil {
+		return nil, rateLimit{}, err
+	}
+	node, limit, err := bb.releaseBackend.releaseByTag(ctx, owner, repo, tag, pattern)
+	bb.breaker.observe(limit, err)
+	return node, limit, err
+}
+
+func (bb breakerBackend) releases(ctx context.Context, owner, repo string, pattern assetPattern, first int, cursor string) (releasePage, rateLimit, error) {
+	if err := bb.breaker.check(); err != nil {
+		return releasePage{}, rateLimit{}, err
+	}
+	page, limit, err := bb.releaseBackend.releases(ctx, owner, repo, pattern, first, cursor)
+	bb.breaker.observe(limit, err)
+	return page, limit, err
+}
@@ -1,70 +1,223 @@
 package main
 
 import (
+	"container/list"
+	"errors"
 	"sync"
 	"time"
 )
 
+// itemOverhead approximates the memory used by an entry besides its key, value and error message.
+const itemOverhead = 128
+
 type item struct {
-	value      string
-	err        error
-	lastAccess int64
+	key      string
+	value    string
+	err      error
+	storedAt time.Time
+	ttl      time.Duration
+	size     int64
+	elem     *list.Element
 }
 
 type Cacher interface {
-	Put(k, v string, err error)
-	Get(k string) (v string, err error)
+	// Put stores the value `v` or the error `err` for `k` for the duration `ttl`. Nothing is stored if `ttl` is
+	// not positive.
+	Put(k, v string, err error, ttl time.Duration)
+	// Get retrieves the value or error stored for `k`. Stale values, which are older than their TTL, may still be
+	// returned but should be refreshed.
+	Get(k string) (v string, stale bool, err error)
 }
 
+// GitReleasesCache is an in-memory `Cacher` limited in the number of entries and their approximate size in bytes.
+// The least recently used entries are evicted once a limit is exceeded.
 type GitReleasesCache struct {
+	maxEntries int
+	maxBytes   int64
+	staleTTL   time.Duration
+
+	l     sync.Mutex
 	items map[string]*item
-	l     sync.RWMutex
+	lru   *list.List
+	size  int64
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
-// NewCache is a simple cache with expiration time (TTL).
+// NewCache creates an in-memory cache holding at most `maxEntries` entries using approximately `maxBytes` bytes, a
+// limit of zero disables it. Once a limit is exceeded, the least recently read or written entries are evicted.
 //
-// I was a bit lazy so I took the code mostly from:
-// https://stackoverflow.com/questions/25484122/map-with-ttl-option-in-go
-//
-// I just changed the mutex to an RWMutex and added the possibility to do specify the ticking time.
-func NewCache(ln int, maxTTL int, tickInterval time.Duration) (m *GitReleasesCache) {
-	m = &GitReleasesCache{items: make(map[string]*item, ln)}
+// Entries are fresh for their TTL after they have been stored, no matter how often they are read. Afterwards values
+// are served as stale for another `staleTTL`, giving the caller time to refresh them, before they expire. Errors
+// expire right away. Expired entries are removed every `tickInterval` until the cache is closed.
+func NewCache(maxEntries int, maxBytes int64, staleTTL time.Duration, tickInterval time.Duration) (m *GitReleasesCache) {
+	m = &GitReleasesCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		staleTTL:   staleTTL,
+		items:      make(map[string]*item),
+		lru:        list.New(),
+		stop:       make(chan struct{}),
+	}
+	ticker := time.NewTicker(tickInterval)
 	go func() {
-		for now := range time.Tick(tickInterval) {
-			m.l.Lock()
-			for k, v := range m.items {
-				if now.Unix()-v.lastAccess > int64(maxTTL) {
-					delete(m.items, k)
-				}
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.sweep(now)
+			case <-m.stop:
+				return
 			}
-			m.l.Unlock()
 		}
 	}()
 	return
 }
 
-// Put adds `v` using the key `k` to the cache. Error is optional and can be used to store an error in the cache for faster error lookups.
-func (m *GitReleasesCache) Put(k, v string, err error) {
+// Close stops removing expired entries in the background. The cache can still be used afterwards.
+func (m *GitReleasesCache) Close() error {
+	m.closeOnce.Do(func() { close(m.stop) })
+	return nil
+}
+
+func (m *GitReleasesCache) expired(it *item, now time.Time) bool {
+	if it.err != nil {
+		return now.Sub(it.storedAt) > it.ttl
+	}
+	return now.Sub(it.storedAt) > it.ttl+m.staleTTL
+}
+
+// sweep removes all entries expired at `now`.
+func (m *GitReleasesCache) sweep(now time.Time) {
 	m.l.Lock()
-	it, ok := m.items[k]
-	if !ok {
-		it = &item{value: v, err: err}
-		m.items[k] = it
+	defer m.l.Unlock()
+	for _, it := range m.items {
+		if m.expired(it, now) {
+			m.remove(it, "expired")
+		}
+	}
+}
+
+// remove deletes the entry `it` evicted for `reason`. It has to be called with the lock held.
+func (m *GitReleasesCache) remove(it *item, reason string) {
+	m.lru.Remove(it.elem)
+	delete(m.items, it.key)
+	m.size -= it.size
+	cacheEntriesGauge.WithLabelValues("memory").Dec()
+	cacheBytesGauge.WithLabelValues("memory").Sub(float64(it.size))
+	if reason != "" {
+		cacheEvictionsCounter.WithLabelValues("memory", reason).Inc()
+	}
+}
+
+// full returns true if the cache exceeds one of its limits. It has to be called with the lock held.
+func (m *GitReleasesCache) full() bool {
+	return (m.maxEntries > 0 && len(m.items) > m.maxEntries) || (m.maxBytes > 0 && m.size > m.maxBytes)
+}
+
+// Put adds `v` using the key `k` to the cache for `ttl`, replacing a previous entry. Error is optional and can be
+// used to store an error in the cache for faster error lookups.
+//
+// The least recently used entries are evicted if the cache exceeds its limits, entries larger than the cache are
+// not stored at all.
+func (m *GitReleasesCache) Put(k, v string, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	it := &item{key: k, value: v, err: err, storedAt: time.Now(), ttl: ttl, size: int64(len(k) + len(v) + itemOverhead)}
+	if err != nil {
+		it.size += int64(len(err.Error()))
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	if previous, ok := m.items[k]; ok {
+		m.remove(previous, "")
+	}
+	if m.maxBytes > 0 && it.size > m.maxBytes {
+		return
+	}
+	it.elem = m.lru.PushFront(it)
+	m.items[k] = it
+	m.size += it.size
+	cacheEntriesGauge.WithLabelValues("memory").Inc()
+	cacheBytesGauge.WithLabelValues("memory").Add(float64(it.size))
+
+	for m.full() {
+		m.remove(m.lru.Back().Value.(*item), "capacity")
 	}
-	it.lastAccess = time.Now().Unix()
-	m.l.Unlock()
 }
 
 // Get retrieves by key `k` the value. If `err` is non nil, this probably means an error has been cached explicitely.
 //
 // A not cached value is indicated using an empty string for `v` and a nil error.
-func (m *GitReleasesCache) Get(k string) (v string, err error) {
-	m.l.RLock()
-	if it, ok := m.items[k]; ok {
-		v = it.value
-		err = it.err
-		it.lastAccess = time.Now().Unix()
+func (m *GitReleasesCache) Get(k string) (v string, stale bool, err error) {
+	now := time.Now()
+	m.l.Lock()
+	defer m.l.Unlock()
+	it, ok := m.items[k]
+	if ok && m.expired(it, now) {
+		m.remove(it, "expired")
+		ok = false
 	}
-	m.l.RUnlock()
-	return
+	if !ok {
+		cacheMissesCounter.WithLabelValues("memory").Inc()
+		return
+	}
+	cacheHitsCounter.WithLabelValues("memory").Inc()
+	m.lru.MoveToFront(it.elem)
+	return it.value, now.Sub(it.storedAt) > it.ttl, it.err
+}
+
+// cachedEntry is the serialized form of a cache entry, as stored by `RedisCache` and `DiskCache`.
+type cachedEntry struct {
+	Value    string        `json:"value,omitempty"`
+	Error    *cachedError  `json:"error,omitempty"`
+	StoredAt time.Time     `json:"storedAt"`
+	TTL      time.Duration `json:"ttl"`
+	// ExpiresAt is set if the store does not expire the entry by itself.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// cachedError is the serialized form of a cached error, which keeps the information the API needs to answer it.
+type cachedError struct {
+	Message string `json:"message"`
+	// Type is set for `GitHubError`s.
+	Type *GitHubErrorType `json:"type,omitempty"`
+	// Ambiguous is set for `ambiguousAssetError`s, the choices are listed to the client.
+	Ambiguous *ambiguousAssetError `json:"ambiguous,omitempty"`
+}
+
+// encodeError serializes `err`, nil if there is no error.
+func encodeError(err error) *cachedError {
+	if err == nil {
+		return nil
+	}
+	c := &cachedError{Message: err.Error()}
+	switch t := err.(type) {
+	case GitHubError:
+		c.Type = &t.Type
+	case ambiguousAssetError:
+		c.Ambiguous = &t
+	}
+	return c
+}
+
+// decode restores the serialized error. The well-known errors such as `errReleaseNotFound` are restored as is.
+func (c *cachedError) decode() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Ambiguous != nil:
+		return *c.Ambiguous
+	case c.Type == nil:
+		return errors.New(c.Message)
+	}
+	for _, known := range []GitHubError{errReleaseNotFound, errAssetNotFound} {
+		if known.Type == *c.Type && known.Error() == c.Message {
+			return known
+		}
+	}
+	return NewGitHubError(c.Message, *c.Type)
 }
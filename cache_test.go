@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGitReleasesCache_TTL(t *testing.T) {
+	cache := NewCache(10, 0, 50*time.Millisecond, time.Hour)
+	cache.Put("key", "value", nil, 50*time.Millisecond)
+
+	// reading an entry does not extend its lifetime.
+	for i := 0; i < 3; i++ {
+		if v, stale, _ := cache.Get("key"); v != "value" || stale {
+			t.Fatalf("expected fresh value, got '%s' (stale: %v)", v, stale)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, stale, _ := cache.Get("key"); v != "value" || !stale {
+		t.Errorf("expected stale value, got '%s' (stale: %v)", v, stale)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if v, _, _ := cache.Get("key"); v != "" {
+		t.Errorf("expected expired value, got '%s'", v)
+	}
+}
+
+// countingProvider resolves every release to an URL containing the number of the lookup.
+type countingProvider struct {
+	calls int32
+}
+
+func (cp *countingProvider) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	n := atomic.AddInt32(&cp.calls, 1)
+	return resolvedRelease{TagName: tag, Assets: releaseAssetNodes{{Name: string(pattern), DownloadUrl: fmt.Sprintf("https://example.com/%d", n)}}}, nil
+}
+
+func TestReleaseResolver_FetchReleaseURL_StaleWhileRevalidate(t *testing.T) {
+	provider := &countingProvider{}
+	rr := newReleaseResolver("test", provider, NewCache(10, 0, time.Hour, time.Hour))
+	rr.SetCacheTTLs(50*time.Millisecond, time.Minute, 0)
+
+	fetch := func() string {
+		url, err := rr.FetchReleaseURL(context.Background(), "owner", "repo", "latest", "tool.zip")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return url
+	}
+	if url := fetch(); url != "https://example.com/1" {
+		t.Fatalf("unexpected url '%s'", url)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	// the stale entry is served while a single refresh is running.
+	for i := 0; i < 5; i++ {
+		if url := fetch(); url != "https://example.com/1" {
+			t.Errorf("expected stale url, got '%s'", url)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if url := fetch(); url != "https://example.com/2" {
+		t.Errorf("expected refreshed url, got '%s'", url)
+	}
+	if calls := atomic.LoadInt32(&provider.calls); calls != 2 {
+		t.Errorf("expected 2 lookups, got %d", calls)
+	}
+}
+
+func TestCachePolicy_TTL(t *testing.T) {
+	policy := cachePolicy{ttl: time.Hour, notFoundTTL: time.Minute, errorTTL: time.Second}
+	for name, data := range map[string]struct {
+		Err error
+		TTL time.Duration
+	}{
+		"success":           {TTL: time.Hour},
+		"release not found": {Err: errReleaseNotFound, TTL: time.Minute},
+		"asset not found":   {Err: errAssetNotFound, TTL: time.Minute},
+		"ambiguous asset":   {Err: ambiguousAssetError{}, TTL: time.Minute},
+		"server error":      {Err: NewGitHubError("bad gateway", TypeUpstream), TTL: time.Second},
+		"timeout":           {Err: NewGitHubError("timeout", TypeTimeout), TTL: time.Second},
+		"rate limited":      {Err: GitHubError{rateLimitedError{}, TypeRateLimited}, TTL: 0},
+		"cancelled":         {Err: context.Canceled, TTL: 0},
+		"deadline exceeded": {Err: context.DeadlineExceeded, TTL: 0},
+		"other":             {Err: errors.New("unexpected"), TTL: time.Second},
+	} {
+		if ttl := policy.ttlOf(data.Err); ttl != data.TTL {
+			t.Errorf("%s: expected TTL %s, got %s", name, data.TTL, ttl)
+		}
+	}
+}
+
+func TestGitReleasesCache_ErrorTTL(t *testing.T) {
+	cache := NewCache(10, 0, time.Hour, time.Hour)
+	cache.Put("error", "", errReleaseNotFound, 20*time.Millisecond)
+	cache.Put("disabled", "", errReleaseNotFound, 0)
+
+	if _, _, err := cache.Get("error"); err != errReleaseNotFound {
+		t.Errorf("expected cached error, got %v", err)
+	}
+	if _, _, err := cache.Get("disabled"); err != nil {
+		t.Errorf("expected nothing to be cached, got %v", err)
+	}
+	// errors are not served as stale.
+	time.Sleep(30 * time.Millisecond)
+	if _, _, err := cache.Get("error"); err != nil {
+		t.Errorf("expected expired error, got %v", err)
+	}
+}
+
+func TestGitReleasesCache_Eviction(t *testing.T) {
+	cache := NewCache(2, 0, time.Hour, time.Hour)
+	defer cache.Close()
+	cache.Put("a", "1", nil, time.Hour)
+	cache.Put("b", "2", nil, time.Hour)
+	// reading "a" makes "b" the least recently used entry.
+	cache.Get("a")
+	cache.Put("c", "3", nil, time.Hour)
+
+	for k, expected := range map[string]string{"a": "1", "b": "", "c": "3"} {
+		if v, _, _ := cache.Get(k); v != expected {
+			t.Errorf("%s: expected '%s', got '%s'", k, expected, v)
+		}
+	}
+}
+
+func TestGitReleasesCache_MaxBytes(t *testing.T) {
+	cache := NewCache(0, 2*itemOverhead+10, time.Hour, time.Hour)
+	defer cache.Close()
+	cache.Put("a", "1", nil, time.Hour)
+	cache.Put("b", "2", nil, time.Hour)
+	cache.Put("c", "3", nil, time.Hour)
+	if v, _, _ := cache.Get("a"); v != "" {
+		t.Errorf("expected evicted entry, got '%s'", v)
+	}
+	if cache.size > cache.maxBytes {
+		t.Errorf("expected at most %d bytes, got %d", cache.maxBytes, cache.size)
+	}
+
+	// entries exceeding the cache are not stored.
+	cache.Put("large", strings.Repeat("x", 3*itemOverhead), nil, time.Hour)
+	if v, _, _ := cache.Get("large"); v != "" {
+		t.Errorf("expected large entry not to be cached")
+	}
+	if v, _, _ := cache.Get("c"); v != "3" {
+		t.Errorf("expected '3', got '%s'", v)
+	}
+}
+
+func TestGitReleasesCache_Sweep(t *testing.T) {
+	cache := NewCache(10, 0, 0, 10*time.Millisecond)
+	cache.Put("key", "value", nil, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	cache.Close()
+
+	cache.l.Lock()
+	defer cache.l.Unlock()
+	if len(cache.items) != 0 || cache.lru.Len() != 0 || cache.size != 0 {
+		t.Errorf("expected expired entry to be removed, got %d entries of %d bytes", len(cache.items), cache.size)
+	}
+}
+
+func TestReleaseResolver_FetchReleaseURL_CancelNotCached(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	cache := NewCache(10, 0, time.Hour, time.Hour)
+	defer cache.Close()
+	rr := newReleaseResolver("test", provider, cache)
+
+	// the caller gives up, its error is not cached for later callers.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rr.FetchReleaseURL(ctx, "owner", "repo", "v1", "tool.zip"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	close(provider.release)
+	url, err := rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip")
+	if url != "https://example.com/v1" || err != nil {
+		t.Errorf("unexpected url '%s' and err '%v'", url, err)
+	}
+}
+
+// timeoutProvider resolves the first lookup, later lookups exceed their deadline.
+type timeoutProvider struct {
+	calls int32
+}
+
+func (tp *timeoutProvider) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	if atomic.AddInt32(&tp.calls, 1) > 1 {
+		return resolvedRelease{}, context.DeadlineExceeded
+	}
+	return resolvedRelease{TagName: tag, Assets: releaseAssetNodes{{Name: string(pattern), DownloadUrl: "https://example.com/" + tag}}}, nil
+}
+
+func TestReleaseResolver_FetchReleaseURL_RefreshTimeout(t *testing.T) {
+	provider := &timeoutProvider{}
+	cache := NewCache(10, 0, time.Hour, time.Hour)
+	defer cache.Close()
+	rr := newReleaseResolver("test", provider, cache)
+	rr.SetCacheTTLs(20*time.Millisecond, time.Minute, time.Minute)
+
+	if _, err := rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip")
+	time.Sleep(20 * time.Millisecond)
+
+	// the refresh did not finish in time, the stale entry is kept.
+	url, err := rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip")
+	if url != "https://example.com/v1" || err != nil {
+		t.Errorf("expected stale url, got '%s' and err '%v'", url, err)
+	}
+}
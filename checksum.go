@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxManifestSize limits how much of a checksum manifest is read.
+	maxManifestSize = 1 << 20
+	// manifestTimeout limits the time spent downloading a checksum manifest.
+	manifestTimeout = 10 * time.Second
+)
+
+// manifestClient downloads checksum manifests. Download URLs of public repositories don't need any authentication,
+// the credentials of the providers are not sent to the storage the downloads are redirected to.
+var manifestClient = &http.Client{Timeout: manifestTimeout}
+
+var (
+	errManifestNotFound = NewGitHubError("github: checksum manifest not found", TypeNotFound)
+	errChecksumNotFound = NewGitHubError("github: checksum not found in manifest", TypeNotFound)
+
+	sha256Digest    = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	bsdChecksumLine = regexp.MustCompile(`^SHA256 \((.+)\) = ([0-9a-fA-F]{64})$`)
+	// fileExtension matches names ending in an extension such as `.zip`, but not in a version such as `_1.4.2`.
+	fileExtension = regexp.MustCompile(`\.[a-z][a-z0-9]*$`)
+)
+
+// checksum is the SHA-256 digest of a release asset as listed in a checksum manifest of the same release.
+type checksum struct {
+	Asset    string `json:"asset"`
+	Manifest string `json:"manifest"`
+	SHA256   string `json:"sha256"`
+}
+
+// checksumManifests returns the checksum manifests of a release. Manifests specific to `assetName`
+// (e.g. `tool.tar.gz.sha256`) are returned before manifests listing all assets (e.g. `SHA256SUMS`,
+// `tool_1.0.0_SHA256SUMS`, `checksums.txt` or `tool_1.0.0.sha256`).
+func checksumManifests(assetName string, assets releaseAssetNodes) releaseAssetNodes {
+	names := make(map[string]bool, len(assets))
+	for _, asset := range assets {
+		names[strings.ToLower(asset.Name)] = true
+	}
+	assetName = strings.ToLower(assetName)
+
+	var specific, shared releaseAssetNodes
+	for _, asset := range assets {
+		name := strings.ToLower(asset.Name)
+		switch {
+		case hasAnySuffix(name, ".sha256", ".sha256sum"):
+			stem := name[:strings.LastIndex(name, ".")]
+			if stem == assetName {
+				specific = append(specific, asset)
+			} else if !names[stem] && !fileExtension.MatchString(stem) {
+				// the manifest does not belong to another asset such as `tool.zip.sha256`.
+				shared = append(shared, asset)
+			}
+		case hasAnySuffix(name, "sha256sums", "sha256sums.txt", "checksums.txt"):
+			shared = append(shared, asset)
+		}
+	}
+	return append(specific, shared...)
+}
+
+// parseChecksumManifest parses manifests in the GNU coreutils (`<digest>  <name>` or `<digest> *<name>`) and in the
+// BSD (`SHA256 (<name>) = <digest>`) format. A line consisting of a digest only is stored using `defaultName`.
+func parseChecksumManifest(r io.Reader, defaultName string) (map[string]string, error) {
+	digests := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+			digests[path.Base(m[1])] = strings.ToLower(m[2])
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !sha256Digest.MatchString(fields[0]) {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimSpace(line[len(fields[0]):]), "*")
+		if name == "" {
+			name = defaultName
+		}
+		digests[path.Base(name)] = strings.ToLower(fields[0])
+	}
+	return digests, scanner.Err()
+}
+
+// downloadChecksumManifest retrieves the manifest using `httpClient` and parses it.
+func downloadChecksumManifest(ctx context.Context, httpClient *http.Client, manifest releaseAsset, defaultName string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, manifest.DownloadUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, transportError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewGitHubError(fmt.Sprintf("github: downloading checksum manifest %s failed: %s", manifest.Name, resp.Status), TypeUpstream)
+	}
+	return parseChecksumManifest(io.LimitReader(resp.Body, maxManifestSize), defaultName)
+}
+
+// FetchChecksum looks up the SHA-256 digest of the asset `assetName` in the checksum manifests of the release
+// specified by `tag`.
+func (rr *releaseResolver) FetchChecksum(ctx context.Context, owner, repo, tag, assetName string) (checksum, error) {
+	var c checksum
+	cached, err := rr.cached(ctx, rr.cacheKey(owner, repo, tag, assetName, "sha256"), func(ctx context.Context) (string, error) {
+		found, err := rr.fetchChecksum(ctx, owner, repo, tag, assetPattern(assetName))
+		if err != nil {
+			return "", err
+		}
+		encoded, err := json.Marshal(&found)
+		return string(encoded), err
+	})
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal([]byte(cached), &c)
+	return c, err
+}
+
+func (rr *releaseResolver) fetchChecksum(ctx context.Context, owner, repo, tag string, pattern assetPattern) (checksum, error) {
+	release, err := rr.fetchRelease(ctx, owner, repo, tag, pattern)
+	if err != nil {
+		return checksum{}, err
+	}
+	asset := release.Assets[0]
+
+	// the asset lookup might have been filtered by name, the manifests are part of the complete asset list.
+	all, err := rr.fetchRelease(ctx, owner, repo, release.TagName, assetPattern("*"))
+	if err != nil {
+		return checksum{}, err
+	}
+
+	manifests := checksumManifests(asset.Name, all.Assets)
+	if len(manifests) == 0 {
+		return checksum{}, errManifestNotFound
+	}
+	for _, manifest := range manifests {
+		defaultName := ""
+		if strings.HasPrefix(strings.ToLower(manifest.Name), strings.ToLower(asset.Name)+".") {
+			defaultName = asset.Name
+		}
+		digests, err := rr.fetchChecksumManifest(ctx, owner, repo, release.TagName, manifest, defaultName)
+		if err != nil {
+			return checksum{}, err
+		}
+		if digest, ok := digests[asset.Name]; ok {
+			return checksum{Asset: asset.Name, Manifest: manifest.Name, SHA256: digest}, nil
+		}
+	}
+	return checksum{}, errChecksumNotFound
+}
+
+// fetchChecksumManifest returns the parsed checksum manifest of the release `tag`. Manifests are cached per release,
+// the assets of a release share a single download.
+func (rr *releaseResolver) fetchChecksumManifest(ctx context.Context, owner, repo, tag string, manifest releaseAsset, defaultName string) (map[string]string, error) {
+	var digests map[string]string
+	cached, err := rr.cached(ctx, rr.cacheKey(owner, repo, tag, manifest.Name, "manifest"), func(ctx context.Context) (string, error) {
+		found, err := downloadChecksumManifest(ctx, rr.manifestClient, manifest, defaultName)
+		if err != nil {
+			return "", err
+		}
+		encoded, err := json.Marshal(found)
+		return string(encoded), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(cached), &digests)
+	return digests, err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const checksumManifest = `
+# generated by goreleaser
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  tool_1.4.2_linux_amd64.tar.gz
+2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE *tool_1.4.2_windows_amd64.zip
+SHA256 (./tool_1.4.2_darwin_amd64.tar.gz) = fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9
+not a checksum line
+`
+
+var parseChecksumManifestCases = map[string]struct {
+	Manifest    string
+	DefaultName string
+	Asset       string
+	Digest      string
+}{
+	"gnu text mode": {
+		Manifest: checksumManifest,
+		Asset:    "tool_1.4.2_linux_amd64.tar.gz",
+		Digest:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	},
+	"gnu binary mode": {
+		Manifest: checksumManifest,
+		Asset:    "tool_1.4.2_windows_amd64.zip",
+		Digest:   "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+	},
+	"bsd": {
+		Manifest: checksumManifest,
+		Asset:    "tool_1.4.2_darwin_amd64.tar.gz",
+		Digest:   "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+	},
+	"digest only": {
+		Manifest:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n",
+		DefaultName: "tool.tar.gz",
+		Asset:       "tool.tar.gz",
+		Digest:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	},
+	"missing": {
+		Manifest: checksumManifest,
+		Asset:    "tool_1.4.2_linux_arm64.tar.gz",
+	},
+}
+
+func TestParseChecksumManifest(t *testing.T) {
+	for name, data := range parseChecksumManifestCases {
+		t.Run(name, func(t *testing.T) {
+			digests, err := parseChecksumManifest(strings.NewReader(data.Manifest), data.DefaultName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if digests[data.Asset] != data.Digest {
+				t.Errorf("digest does not match. Expected: '%s', got '%s'", data.Digest, digests[data.Asset])
+			}
+		})
+	}
+}
+
+func TestChecksumManifests(t *testing.T) {
+	assets := releaseAssetNodes{
+		{Name: "checksums.txt"},
+		{Name: "tool.tar.gz"},
+		{Name: "tool.tar.gz.sha256"},
+		{Name: "tool.zip.sha256"},
+	}
+	manifests := checksumManifests("tool.tar.gz", assets)
+	if len(manifests) != 2 || manifests[0].Name != "tool.tar.gz.sha256" || manifests[1].Name != "checksums.txt" {
+		t.Errorf("unexpected manifests: %v", manifests)
+	}
+}
+
+func TestChecksumManifests_Names(t *testing.T) {
+	for name, data := range map[string]struct {
+		Assets   []string
+		Expected []string
+	}{
+		"sha256sums":           {Assets: []string{"SHA256SUMS", "SHA256SUMS.asc", "sha256sums.txt"}, Expected: []string{"SHA256SUMS", "sha256sums.txt"}},
+		"versioned sha256sums": {Assets: []string{"terraform_1.5.0_SHA256SUMS", "terraform_1.5.0_SHA256SUMS.sig"}, Expected: []string{"terraform_1.5.0_SHA256SUMS"}},
+		"goreleaser":           {Assets: []string{"tool_1.4.2_checksums.txt"}, Expected: []string{"tool_1.4.2_checksums.txt"}},
+		"generic sha256":       {Assets: []string{"tool_1.4.2.sha256", "checksums.sha256"}, Expected: []string{"tool_1.4.2.sha256", "checksums.sha256"}},
+		"other asset":          {Assets: []string{"other", "other.sha256", "tool.zip.sha256"}},
+		"asset specific":       {Assets: []string{"SHA256SUMS", "TOOL.tar.gz.sha256sum"}, Expected: []string{"TOOL.tar.gz.sha256sum", "SHA256SUMS"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var assets releaseAssetNodes
+			for _, asset := range append([]string{"tool.tar.gz"}, data.Assets...) {
+				assets = append(assets, releaseAsset{Name: asset})
+			}
+			var manifests []string
+			for _, manifest := range checksumManifests("tool.tar.gz", assets) {
+				manifests = append(manifests, manifest.Name)
+			}
+			if fmt.Sprint(manifests) != fmt.Sprint(data.Expected) {
+				t.Errorf("manifests do not match. Expected: %v, got %v", data.Expected, manifests)
+			}
+		})
+	}
+}
+
+// manifestProvider resolves every release to `assets`, filtered by the requested asset name.
+type manifestProvider struct {
+	assets releaseAssetNodes
+}
+
+func (mp manifestProvider) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	release := resolvedRelease{TagName: "v1.0.0"}
+	for _, asset := range mp.assets {
+		if pattern == "*" || string(pattern) == asset.Name {
+			release.Assets = append(release.Assets, asset)
+		}
+	}
+	return release, nil
+}
+
+func TestReleaseResolver_FetchChecksum_ManifestCached(t *testing.T) {
+	var downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		fmt.Fprint(w, checksumManifest)
+	}))
+	defer server.Close()
+
+	cache := NewCache(10, 0, time.Hour, time.Hour)
+	defer cache.Close()
+	rr := newReleaseResolver("test", manifestProvider{releaseAssetNodes{
+		{Name: "tool_1.4.2_linux_amd64.tar.gz"},
+		{Name: "tool_1.4.2_windows_amd64.zip"},
+		{Name: "tool_1.4.2_checksums.txt", DownloadUrl: server.URL},
+	}}, cache)
+
+	for asset, digest := range map[string]string{
+		"tool_1.4.2_linux_amd64.tar.gz": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"tool_1.4.2_windows_amd64.zip":  "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+	} {
+		c, err := rr.FetchChecksum(context.Background(), "owner", "repo", "latest", asset)
+		if err != nil || c.SHA256 != digest {
+			t.Errorf("%s: expected digest '%s', got '%s' and err '%v'", asset, digest, c.SHA256, err)
+		}
+	}
+	// the manifest has been downloaded once for both assets.
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("expected a single manifest download, got %d", n)
+	}
+}
+
+func TestReleaseResolver_FetchChecksum_ManifestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	rr := newReleaseResolver("test", manifestProvider{releaseAssetNodes{
+		{Name: "tool.tar.gz"},
+		{Name: "checksums.txt", DownloadUrl: server.URL},
+	}}, &NoopCache{})
+	rr.manifestClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := rr.FetchChecksum(context.Background(), "owner", "repo", "latest", "tool.tar.gz"); err == nil {
+		t.Error("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the download to time out, took %v", elapsed)
+	}
+}
+
+func TestApiServer_Checksum_Access(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, checksumManifest)
+	}))
+	defer server.Close()
+
+	cache := NoopCache{}
+	gh := NewGitHubClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	gh.provider = manifestProvider{releaseAssetNodes{
+		{Name: "tool_1.4.2_linux_amd64.tar.gz"},
+		{Name: "checksums.txt", DownloadUrl: server.URL},
+	}}
+	gl := NewGitLabClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	access := proxyAccess{"gh/testing/*": "caller"}
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, access, discardLogger())
+
+	for name, data := range map[string]struct {
+		URL    string
+		Token  string
+		Status int
+	}{
+		"granted":       {URL: "/gh/testing/testing/latest/tool_1.4.2_linux_amd64.tar.gz/sha256", Token: "caller", Status: http.StatusOK},
+		"missing token": {URL: "/gh/testing/testing/latest/tool_1.4.2_linux_amd64.tar.gz/sha256", Status: http.StatusUnauthorized},
+		"wrong token":   {URL: "/gh/testing/testing/latest/tool_1.4.2_linux_amd64.tar.gz/sha256", Token: "wrong", Status: http.StatusUnauthorized},
+		"not covered":   {URL: "/gh/other/testing/latest/tool_1.4.2_linux_amd64.tar.gz/sha256", Token: "caller", Status: http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, data.URL, nil)
+			if data.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+data.Token)
+			}
+			w := httptest.NewRecorder()
+			as.server.Handler.ServeHTTP(w, r)
+			if w.Code != data.Status {
+				t.Fatalf("status does not match. Expected: %d, got %d: %s", data.Status, w.Code, w.Body.String())
+			}
+			if data.Status == http.StatusOK && strings.TrimSpace(w.Body.String()) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+				t.Errorf("unexpected digest '%s'", w.Body.String())
+			}
+		})
+	}
+}
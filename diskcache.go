@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	bolt "go.etcd.io/bbolt"
+)
+
+// diskCacheBucket is the bucket of the cache file holding the entries.
+var diskCacheBucket = []byte("lookups")
+
+// DiskCache is a `Cacher` persisting the entries together with their expiry in a bbolt file, a restarted instance
+// answers the lookups cached before. It trades the memory limits of `GitReleasesCache` for disk space: entries are
+// read from the file when they are looked up, the file is not loaded at startup.
+//
+// Every commit waits for the file to be synced. Entries are therefore written in the background, lookups do not wait
+// for the disk, and pending entries are answered from memory until they are written. Entries written shortly before
+// a crash may be lost. Expired entries are removed by rewriting the file, which shrinks it again.
+type DiskCache struct {
+	path     string
+	staleTTL time.Duration
+	logger   log.Logger
+
+	// l guards `db`, which is replaced when the file is compacted, and `pending`.
+	l  sync.RWMutex
+	db *bolt.DB
+	// pending are the encoded entries not written to the file yet.
+	pending map[string][]byte
+	written chan struct{}
+
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// openDiskCacheFile opens the bbolt file `path` and creates the bucket of the entries.
+func openDiskCacheFile(path string) (*bolt.DB, error) {
+	// the file is locked while it is open, another instance using the same file fails instead of waiting forever.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewDiskCache opens or creates the cache file `path`, which cannot be shared with another instance. The expiry is
+// stored with every entry, values `staleTTL` after their TTL and errors right after it. The file is rewritten
+// without the expired entries every `compactInterval`.
+func NewDiskCache(path string, staleTTL, compactInterval time.Duration, logger log.Logger) (*DiskCache, error) {
+	db, err := openDiskCacheFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &DiskCache{
+		path:     path,
+		staleTTL: staleTTL,
+		logger:   logger,
+		db:       db,
+		pending:  make(map[string][]byte),
+		written:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	// writes and compaction run in the same goroutine, the file is never written while it is being compacted.
+	ticker := time.NewTicker(compactInterval)
+	go func() {
+		defer close(dc.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dc.written:
+				dc.flush()
+			case now := <-ticker.C:
+				dc.flush()
+				if err := dc.compact(now); err != nil {
+					dc.logger.Error("cannot compact cache file", "err", err)
+				}
+			case <-dc.stop:
+				dc.flush()
+				return
+			}
+		}
+	}()
+	return dc, nil
+}
+
+// Close writes the pending entries, stops removing expired entries and closes the cache file.
+func (dc *DiskCache) Close() error {
+	var err error
+	dc.closeOnce.Do(func() {
+		close(dc.stop)
+		<-dc.stopped
+		err = dc.db.Close()
+	})
+	return err
+}
+
+// flush writes the pending entries in a single transaction. They stay pending if the transaction fails.
+func (dc *DiskCache) flush() {
+	dc.l.RLock()
+	entries := make(map[string][]byte, len(dc.pending))
+	for k, encoded := range dc.pending {
+		entries[k] = encoded
+	}
+	db := dc.db
+	dc.l.RUnlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskCacheBucket)
+		for k, encoded := range entries {
+			if err := bucket.Put([]byte(k), encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dc.logger.Error("cannot write cache entries", "entries", len(entries), "err", err)
+		return
+	}
+
+	dc.l.Lock()
+	for k, encoded := range entries {
+		// the entry might have been replaced meanwhile, the replacement is written next time.
+		if bytes.Equal(dc.pending[k], encoded) {
+			delete(dc.pending, k)
+		}
+	}
+	dc.l.Unlock()
+}
+
+// compact copies the entries not expired at `now` into a new file, which replaces the cache file. bbolt reuses the
+// pages of deleted entries but never returns them to the file system, the file would keep the size of its peak
+// otherwise. It has to be called by the goroutine writing the entries.
+func (dc *DiskCache) compact(now time.Time) error {
+	path := dc.path + ".compact"
+	os.Remove(path)
+	compacted, err := openDiskCacheFile(path)
+	if err != nil {
+		return err
+	}
+
+	dc.l.RLock()
+	db := dc.db
+	dc.l.RUnlock()
+	expired, entries, size := 0, 0, int64(0)
+	err = db.View(func(tx *bolt.Tx) error {
+		return compacted.Update(func(dst *bolt.Tx) error {
+			bucket := dst.Bucket(diskCacheBucket)
+			err := tx.Bucket(diskCacheBucket).ForEach(func(k, v []byte) error {
+				var entry cachedEntry
+				if err := json.Unmarshal(v, &entry); err != nil || entry.ExpiresAt == nil || now.After(*entry.ExpiresAt) {
+					expired++
+					return nil
+				}
+				entries++
+				return bucket.Put(k, v)
+			})
+			size = dst.Size()
+			return err
+		})
+	})
+	if err == nil {
+		// the new file is locked by `compacted` already, the old one is unlocked once it is closed.
+		err = os.Rename(path, dc.path)
+	}
+	if err != nil {
+		compacted.Close()
+		os.Remove(path)
+		return err
+	}
+
+	dc.l.Lock()
+	dc.db = compacted
+	dc.l.Unlock()
+	if err := db.Close(); err != nil {
+		dc.logger.Warn("cannot close replaced cache file", "err", err)
+	}
+
+	cacheEvictionsCounter.WithLabelValues("disk", "expired").Add(float64(expired))
+	cacheEntriesGauge.WithLabelValues("disk").Set(float64(entries))
+	cacheBytesGauge.WithLabelValues("disk").Set(float64(size))
+	return nil
+}
+
+// Put stores the entry, it expires after `ttl` if it is an error, otherwise after `ttl` and the stale TTL. The entry
+// is written to the cache file in the background.
+func (dc *DiskCache) Put(k, v string, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if err == nil {
+		expiresAt = expiresAt.Add(dc.staleTTL)
+	}
+	encoded, jsonErr := json.Marshal(cachedEntry{Value: v, Error: encodeError(err), StoredAt: now, TTL: ttl, ExpiresAt: &expiresAt})
+	if jsonErr != nil {
+		dc.logger.Error("cannot encode cache entry", "key", k, "err", jsonErr)
+		return
+	}
+
+	dc.l.Lock()
+	dc.pending[k] = encoded
+	dc.l.Unlock()
+	select {
+	case dc.written <- struct{}{}:
+	default:
+		// a write has been requested already, it includes this entry.
+	}
+}
+
+// Get reads the entry, from memory if it has not been written yet. Expired entries and entries which cannot be
+// decoded are treated as missing.
+func (dc *DiskCache) Get(k string) (v string, stale bool, err error) {
+	dc.l.RLock()
+	encoded, pending := dc.pending[k]
+	var dbErr error
+	if !pending {
+		dbErr = dc.db.View(func(tx *bolt.Tx) error {
+			// the value is only valid during the transaction.
+			if value := tx.Bucket(diskCacheBucket).Get([]byte(k)); value != nil {
+				encoded = append([]byte(nil), value...)
+			}
+			return nil
+		})
+	}
+	dc.l.RUnlock()
+
+	var entry cachedEntry
+	if dbErr == nil && encoded != nil {
+		dbErr = json.Unmarshal(encoded, &entry)
+	}
+	if dbErr != nil {
+		dc.logger.Warn("cannot read cache entry", "key", k, "err", dbErr)
+	}
+	if dbErr != nil || entry.ExpiresAt == nil || !time.Now().Before(*entry.ExpiresAt) {
+		cacheMissesCounter.WithLabelValues("disk").Inc()
+		return
+	}
+	cacheHitsCounter.WithLabelValues("disk").Inc()
+	return entry.Value, time.Since(entry.StoredAt) > entry.TTL, entry.Error.decode()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func tempDiskCacheFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "cache.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestDiskCache_Restart(t *testing.T) {
+	path, cleanup := tempDiskCacheFile(t)
+	defer cleanup()
+
+	dc, err := NewDiskCache(path, time.Hour, time.Hour, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Put("value", "https://example.com/tool.zip", nil, time.Minute)
+	dc.Put("error", "", errReleaseNotFound, time.Minute)
+	dc.Put("disabled", "https://example.com/tool.zip", nil, 0)
+	if err := dc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries are still cached after reopening the file.
+	dc, err = NewDiskCache(path, time.Hour, time.Hour, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	if v, stale, _ := dc.Get("value"); v != "https://example.com/tool.zip" || stale {
+		t.Errorf("expected fresh value, got '%s' (stale: %v)", v, stale)
+	}
+	if _, _, err := dc.Get("error"); err != errReleaseNotFound {
+		t.Errorf("expected errReleaseNotFound, got %v", err)
+	}
+	if v, _, _ := dc.Get("disabled"); v != "" {
+		t.Errorf("expected nothing to be cached, got '%s'", v)
+	}
+}
+
+func TestDiskCache_Expiry(t *testing.T) {
+	path, cleanup := tempDiskCacheFile(t)
+	defer cleanup()
+	dc, err := NewDiskCache(path, 50*time.Millisecond, time.Hour, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+
+	dc.Put("value", "value", nil, 20*time.Millisecond)
+	dc.Put("error", "", NewGitHubError("bad gateway", TypeUpstream), 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if v, stale, _ := dc.Get("value"); v != "value" || !stale {
+		t.Errorf("expected stale value, got '%s' (stale: %v)", v, stale)
+	}
+	if _, _, err := dc.Get("error"); err != nil {
+		t.Errorf("expected expired error, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := dc.compact(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	dc.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(diskCacheBucket).Stats().KeyN; n != 0 {
+			t.Errorf("expected expired entries to be removed, got %d entries", n)
+		}
+		return nil
+	})
+}
+
+func TestDiskCache_Compact(t *testing.T) {
+	path, cleanup := tempDiskCacheFile(t)
+	defer cleanup()
+	dc, err := NewDiskCache(path, 0, time.Hour, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 1000; i++ {
+		dc.Put(fmt.Sprintf("expired%d", i), value, nil, time.Millisecond)
+	}
+	dc.Put("kept", "value", nil, time.Hour)
+	// pending entries are answered before they have been written.
+	if v, _, _ := dc.Get("kept"); v != "value" {
+		t.Errorf("expected pending value, got '%s'", v)
+	}
+	dc.flush()
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := dc.compact(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("expected the file to shrink, got %d bytes before and %d bytes after", before.Size(), after.Size())
+	}
+	if v, _, _ := dc.Get("kept"); v != "value" {
+		t.Errorf("expected value to be kept, got '%s'", v)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be renamed, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent identical lookups into a single call. The call runs detached from the contexts
+// of the callers, a caller giving up does not fail the others. It is cancelled once all callers gave up.
+type flightGroup struct {
+	l       sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a call shared by all callers of the same key.
+type flight struct {
+	done    chan struct{}
+	release resolvedRelease
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do returns the result of `fn`, which is called only once for concurrent calls with the same `key`. The call is
+// bound by the deadline of the caller starting it.
+func (fg *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (resolvedRelease, error)) (resolvedRelease, error) {
+	fg.l.Lock()
+	if fg.flights == nil {
+		fg.flights = make(map[string]*flight)
+	}
+	f, ok := fg.flights[key]
+	if !ok {
+		var flightCtx context.Context
+		var cancel context.CancelFunc
+		if deadline, ok := ctx.Deadline(); ok {
+			flightCtx, cancel = context.WithDeadline(context.Background(), deadline)
+		} else {
+			flightCtx, cancel = context.WithCancel(context.Background())
+		}
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		fg.flights[key] = f
+		go fg.run(flightCtx, key, f, fn)
+	}
+	f.waiters++
+	fg.l.Unlock()
+
+	select {
+	case <-f.done:
+		return f.release, f.err
+	case <-ctx.Done():
+		fg.l.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// later callers start a new flight instead of joining the cancelled one.
+			if fg.flights[key] == f {
+				delete(fg.flights, key)
+			}
+			f.cancel()
+		}
+		fg.l.Unlock()
+		return resolvedRelease{}, ctx.Err()
+	}
+}
+
+func (fg *flightGroup) run(ctx context.Context, key string, f *flight, fn func(ctx context.Context) (resolvedRelease, error)) {
+	release, err := fn(ctx)
+
+	fg.l.Lock()
+	if fg.flights[key] == f {
+		delete(fg.flights, key)
+	}
+	f.release, f.err = release, err
+	f.cancel()
+	close(f.done)
+	fg.l.Unlock()
+}
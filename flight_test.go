@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingProvider resolves every release once `release` is closed.
+type blockingProvider struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (bp *blockingProvider) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	atomic.AddInt32(&bp.calls, 1)
+	select {
+	case <-bp.release:
+	case <-ctx.Done():
+		return resolvedRelease{}, ctx.Err()
+	}
+	return resolvedRelease{TagName: tag, Assets: releaseAssetNodes{{Name: string(pattern), DownloadUrl: "https://example.com/" + tag}}}, nil
+}
+
+func TestReleaseResolver_FetchReleaseURL_Coalesce(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	rr := newReleaseResolver("test", provider, &NoopCache{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip")
+			if url != "https://example.com/v1" || err != nil {
+				t.Errorf("unexpected url '%s' and err '%v'", url, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(provider.release)
+	wg.Wait()
+
+	if provider.calls != 1 {
+		t.Errorf("expected a single lookup, got %d", provider.calls)
+	}
+}
+
+func TestReleaseResolver_FetchReleaseURL_CoalesceCancel(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	rr := newReleaseResolver("test", provider, &NoopCache{})
+
+	// the caller starting the lookup gives up, the other one still receives the result.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := rr.FetchReleaseURL(ctx, "owner", "repo", "v1", "tool.zip")
+		cancelled <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	result := make(chan string)
+	go func() {
+		url, _ := rr.FetchReleaseURL(context.Background(), "owner", "repo", "v1", "tool.zip")
+		result <- url
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	close(provider.release)
+	if url := <-result; url != "https://example.com/v1" {
+		t.Errorf("unexpected url '%s'", url)
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected a single lookup, got %d", provider.calls)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// GiteaClient resolves releases of a Gitea or Forgejo instance.
+type GiteaClient struct {
+	*releaseResolver
+	backend *restBackend
+	search  releaseSearch
+}
+
+// newGiteaBackend creates a backend for the API v1 of Gitea, which is modelled after GitHub's REST API v3 and is
+// therefore served by `restBackend`.
+// Gitea does not enforce rate limits, every request costs a single point.
+func newGiteaBackend(url, token string, httpClient *http.Client) *restBackend {
+	header := make(http.Header)
+	if token != "" {
+		header.Set("Authorization", "token "+token)
+	}
+	// instances may return less releases per page than requested.
+	api := jsonAPI{name: "gitea", baseURL: url, httpClient: httpClient, header: header, pageSize: "limit", nextPage: nextPageByLink}
+	return &restBackend{api, giteaRepoNotFound}
+}
+
+func giteaRepoNotFound(owner, repo string) error {
+	return NewGitHubError(fmt.Sprintf("gitea: repository '%s/%s' not found", owner, repo), TypeNotFound)
+}
+
+// ResolveRelease implements `ReleaseProvider`.
+func (gc *GiteaClient) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	release, _, err := gc.search.resolve(ctx, gc.backend, owner, repo, tag, pattern)
+	return release, err
+}
+
+// SetSearchLimits configures how many releases are inspected at most and how many requests may be sent
+// when paging through the releases of a repository.
+func (gc *GiteaClient) SetSearchLimits(depth, costBudget int) {
+	gc.search.depth = depth
+	gc.search.costBudget = costBudget
+}
+
+// NewGiteaClient creates a GiteaClient for the instance `host` whose API v1 is located at `url`,
+// e.g. `https://codeberg.org/api/v1`. The optional `token` is an access token of the instance.
+//
+// Results are cached in a namespace of their own for every host.
+func NewGiteaClient(host, url, token string, httpClient *http.Client, cache Cacher, logger log.Logger) *GiteaClient {
+	gc := GiteaClient{
+		backend: newGiteaBackend(url, token, httpClient),
+		search:  releaseSearch{depth: defaultSearchDepth, costBudget: defaultCostBudget, logger: logger},
+	}
+	gc.releaseResolver = newReleaseResolver("gitea/"+host, &gc, cache)
+
+	return &gc
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var giteaFixtures = map[string]string{
+	"/repos/testing/testing/releases/tags/v1.0.0": "gitea_release_tag.json",
+	"/repos/testing/testing/releases/latest":      "gitea_release_latest.json",
+}
+
+func giteaHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileName, ok := giteaFixtures[r.URL.Path]
+		if r.URL.Path == "/repos/testing/testing/releases" {
+			ok = true
+			fileName = "gitea_releases_page1.json"
+			if r.URL.Query().Get("page") == "2" {
+				fileName = "gitea_releases_page2.json"
+			} else {
+				w.Header().Set("Link", `<https://gitea.example.com/api/v1/repos/testing/testing/releases?page=2>; rel="next"`)
+			}
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fileName = "gitea_error_not_found.json"
+		}
+		file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.Copy(w, file)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+var fetchReleaseURLResponsesGitea = map[string]struct {
+	Repo        string
+	Tag         string
+	AssetName   string
+	ReturnValue string
+	ReturnError error
+}{
+	"tag": {
+		Repo:        "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitea.example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"release not found": {
+		Repo:        "testing",
+		Tag:         "v0.9.0",
+		AssetName:   "testing.zip",
+		ReturnError: errReleaseNotFound,
+	},
+	"asset not found": {
+		Repo:        "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "testing.tar.gz",
+		ReturnError: errAssetNotFound,
+	},
+	"latest": {
+		Repo:        "testing",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitea.example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"latest prerelease": {
+		Repo:        "testing",
+		Tag:         "latest-prerelease",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitea.example.com/testing/testing/releases/download/v1.1.0-rc.1/testing.zip",
+	},
+	"range": {
+		Repo:        "testing",
+		Tag:         "~1.0",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitea.example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"source archive": {
+		Repo:        "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "source.zip",
+		ReturnValue: "https://gitea.example.com/testing/testing/archive/v1.0.0.zip",
+	},
+	"repo not found": {
+		Repo:        "other",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnError: errors.New("gitea: repository 'testing/other' not found"),
+	},
+}
+
+func TestGiteaClient_FetchReleaseURL(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesGitea {
+		t.Run(name, func(t *testing.T) {
+			httpServer, teardown := testingHTTPClient(giteaHandler("secret"))
+			defer teardown()
+
+			cache := NoopCache{}
+			gc := NewGiteaClient("gitea.example.com", httpServer.URL, "secret", http.DefaultClient, &cache, discardLogger())
+
+			url, err := gc.FetchReleaseURL(context.Background(), "testing", data.Repo, data.Tag, data.AssetName)
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
+
+func TestGiteaClient_FetchReleaseURL_Unauthorized(t *testing.T) {
+	httpServer, teardown := testingHTTPClient(giteaHandler("secret"))
+	defer teardown()
+
+	cache := NoopCache{}
+	gc := NewGiteaClient("gitea.example.com", httpServer.URL, "wrong", http.DefaultClient, &cache, discardLogger())
+
+	_, err := gc.FetchReleaseURL(context.Background(), "testing", "testing", "v1.0.0", "testing.zip")
+	if ghErr, ok := err.(GitHubError); !ok || ghErr.Type != TypeUnauthorized {
+		t.Errorf("expected unauthorized error, got '%v'", err)
+	}
+}
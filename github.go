@@ -4,21 +4,71 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
-	"github.com/shurcooL/githubv4"
 	"golang.org/x/oauth2"
 )
 
 type GithubClient struct {
+	*releaseResolver
 	httpClient *http.Client
-	cache      Cacher
-	client     *githubv4.Client
+	graphql    *graphqlBackend
+	rest       *restBackend
+	mode       APIMode
 	logger     log.Logger
+	search     releaseSearch
+
+	graphqlBreaker circuitBreaker
+	restBreaker    circuitBreaker
+}
+
+// APIMode selects which of GitHub's APIs is used to retrieve releases.
+type APIMode int
+
+const (
+	// ModeAuto uses the GraphQL API and falls back to the REST API on server errors or if the GraphQL points are
+	// exhausted. Both APIs have separate rate limits.
+	ModeAuto APIMode = iota
+	// ModeGraphQL only uses the GraphQL API v4.
+	ModeGraphQL
+	// ModeREST only uses the REST API v3.
+	ModeREST
+)
+
+// ParseAPIMode parses the configuration values `auto`, `graphql` and `rest`.
+func ParseAPIMode(s string) (APIMode, error) {
+	switch s {
+	case "auto":
+		return ModeAuto, nil
+	case "graphql":
+		return ModeGraphQL, nil
+	case "rest":
+		return ModeREST, nil
+	}
+	return ModeAuto, fmt.Errorf("unknown API mode %q", s)
+}
+
+// releaseBackend retrieves releases from the API of a hosting service. Errors are returned as `GitHubError`.
+type releaseBackend interface {
+	// releaseByTag returns the release tagged `tag` or nil if there is none.
+	releaseByTag(ctx context.Context, owner, repo, tag string, pattern assetPattern) (*releaseNode, rateLimit, error)
+	// releases returns a page of at most `first` releases, newest first. An empty cursor requests the first page.
+	releases(ctx context.Context, owner, repo string, pattern assetPattern, first int, cursor string) (releasePage, rateLimit, error)
+}
+
+// releasePage is a single page of releases.
+type releasePage struct {
+	// Latest is the release marked as "Latest" by the hosting service. It is only set on the first page.
+	Latest   *releaseNode
+	Releases []releaseNode
+	// Next is the cursor of the following page, empty if this is the last page.
+	Next string
 }
 
 type rateLimit struct {
@@ -28,39 +78,44 @@ type rateLimit struct {
 	ResetAt   time.Time
 }
 
-type releaseAssetNodes []struct {
+type releaseAsset struct {
+	Name        string
 	DownloadUrl string
 }
 
-type fetchSpecificTag struct {
-	Repository struct {
-		Release *struct {
-			ReleaseAssets struct {
-				Nodes releaseAssetNodes
-			} `graphql:"releaseAssets(name: $assetName, first:1)"`
-		} `graphql:"release(tagName: $tag)"`
-	} `graphql:"repository(owner: $owner, name: $repo)"`
-	RateLimit rateLimit
-}
-type fetchLatestRelease struct {
-	Repository struct {
-		Releases struct {
-			Nodes []struct {
-				ReleaseAssets struct {
-					TotalCount int
-					Nodes      releaseAssetNodes
-				} `graphql:"releaseAssets(name: $assetName, first:1)"`
-			}
-		} `graphql:"releases(first: 5, orderBy: {direction: DESC, field: CREATED_AT})"`
-	} `graphql:"repository(owner: $owner, name: $repo)"`
-	RateLimit rateLimit
+type releaseAssetNodes []releaseAsset
+
+type releaseNode struct {
+	TagName       string
+	IsDraft       bool
+	IsPrerelease  bool
+	TarballUrl    string
+	ZipballUrl    string
+	ReleaseAssets struct {
+		Nodes releaseAssetNodes
+	} `graphql:"releaseAssets(name: $assetName, first: 100)"`
+}
+
+// resolvedRelease is the release selected for a lookup together with its assets matching the requested pattern.
+type resolvedRelease struct {
+	TagName string
+	Assets  releaseAssetNodes
 }
 
 type GitHubErrorType int
 
 const (
 	TypeNotFound GitHubErrorType = iota
-	TypeServerError
+	// TypeUpstream indicates that the API failed to answer or returned an unexpected response.
+	TypeUpstream
+	// TypeRateLimited indicates that no rate limit points are left. The wrapped error is a `rateLimitedError`.
+	TypeRateLimited
+	// TypeForbidden indicates that the credentials lack access, e.g. because of an organization's SAML enforcement.
+	TypeForbidden
+	// TypeUnauthorized indicates that the credentials have been rejected.
+	TypeUnauthorized
+	// TypeTimeout indicates that the API did not answer in time.
+	TypeTimeout
 )
 
 type GitHubError struct {
@@ -76,111 +131,116 @@ func NewGitHubError(text string, t GitHubErrorType) GitHubError {
 	return GitHubError{errors.New(text), t}
 }
 
-var (
-	errReleaseNotFound = NewGitHubError("github: no release found", TypeNotFound)
-	errAssetNotFound   = NewGitHubError("github: asset not found", TypeNotFound)
-)
-
-// parseGraphqlError translates between the unfortunately opaque error type of the graphql library and our own.
-// Specific to GitHub errors and possible to fail if GitHub changes the error messages.
-func parseGraphqlError(err error) GitHubError {
-	text := err.Error()
-	if strings.Contains(text, "Could not resolve to") {
-		return GitHubError{err, TypeNotFound}
+// transportError classifies errors of the HTTP client. Requests which have not been sent because the rate limit
+// of all tokens is exhausted are reported as rate limited.
+func transportError(err error) GitHubError {
+	var limited rateLimitedError
+	if errors.As(err, &limited) {
+		return GitHubError{limited, TypeRateLimited}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return GitHubError{err, TypeTimeout}
 	}
-	return GitHubError{err, TypeServerError}
+	return GitHubError{err, TypeUpstream}
 }
 
-func (gh *GithubClient) fetchLatestRelease(ctx context.Context, owner, repo, assetName string) (releaseAssetNodes, rateLimit, error) {
-	q := fetchLatestRelease{}
-	variables := map[string]interface{}{
-		"owner":     githubv4.String(owner),
-		"repo":      githubv4.String(repo),
-		"assetName": githubv4.String(assetName),
+// rateLimitReset returns when the rate limit indicated by the response headers `h` is reset. Secondary rate limits
+// use `Retry-After`, if neither is present the request is retried after a minute.
+func rateLimitReset(h http.Header) time.Time {
+	if retryAfter, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(retryAfter) * time.Second)
 	}
-
-	err := gh.client.Query(ctx, &q, variables)
-	if err != nil {
-		return nil, q.RateLimit, parseGraphqlError(err)
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil && h.Get("X-RateLimit-Remaining") == "0" {
+		return time.Unix(reset, 0)
 	}
+	return time.Now().Add(time.Minute)
+}
 
-	releases := q.Repository.Releases.Nodes
-	if len(releases) == 0 {
-		return nil, q.RateLimit, errReleaseNotFound
-	}
-	for _, node := range releases {
-		if node.ReleaseAssets.TotalCount > 0 {
-			return node.ReleaseAssets.Nodes, q.RateLimit, nil
+// statusError classifies the unsuccessful HTTP response with the status code `status`, the headers `h` and the
+// error message `message` of the body. `err` describes the failed request.
+func statusError(err error, status int, h http.Header, message string) GitHubError {
+	switch status {
+	case http.StatusUnauthorized:
+		return GitHubError{err, TypeUnauthorized}
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		if status == http.StatusTooManyRequests || h.Get("X-RateLimit-Remaining") == "0" || h.Get("Retry-After") != "" ||
+			strings.Contains(strings.ToLower(message), "rate limit") {
+			return GitHubError{rateLimitedError{ResetAt: rateLimitReset(h)}, TypeRateLimited}
 		}
+		return GitHubError{err, TypeForbidden}
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return GitHubError{err, TypeTimeout}
 	}
-
-	return nil, q.RateLimit, errAssetNotFound
+	return GitHubError{err, TypeUpstream}
 }
 
-func (gh *GithubClient) fetchSpecificTag(ctx context.Context, owner, repo, tag, assetName string) (releaseAssetNodes, rateLimit, error) {
-	q := fetchSpecificTag{}
-	variables := map[string]interface{}{
-		"owner":     githubv4.String(owner),
-		"repo":      githubv4.String(repo),
-		"tag":       githubv4.String(tag),
-		"assetName": githubv4.String(assetName),
-	}
-
-	err := gh.client.Query(ctx, &q, variables)
-	if err != nil {
-		return nil, q.RateLimit, parseGraphqlError(err)
-	}
+var (
+	errReleaseNotFound = NewGitHubError("github: no release found", TypeNotFound)
+	errAssetNotFound   = NewGitHubError("github: asset not found", TypeNotFound)
+)
 
-	release := q.Repository.Release
-	if release == nil {
-		return nil, q.RateLimit, errReleaseNotFound
+// backend returns the API to use according to the configured mode together with its name. The API is guarded by
+// its circuit breaker.
+func (gh *GithubClient) backend() (string, releaseBackend) {
+	switch {
+	case gh.mode == ModeREST:
+		return "rest", breakerBackend{gh.rest, &gh.restBreaker}
+	case gh.mode == ModeAuto && gh.graphql.exhausted():
+		gh.logger.Warn("graphql points exhausted, using rest api")
+		return "rest", breakerBackend{gh.rest, &gh.restBreaker}
+	case gh.mode == ModeAuto && gh.graphqlBreaker.check() != nil:
+		gh.logger.Warn("graphql circuit open, using rest api")
+		return "rest", breakerBackend{gh.rest, &gh.restBreaker}
 	}
-	assets := release.ReleaseAssets.Nodes
-
-	return assets, q.RateLimit, nil
+	return "graphql", breakerBackend{gh.graphql, &gh.graphqlBreaker}
 }
 
-// FetchReleaseURL decides based on the supplied `tag` which GraphQL query is executed.
-func (gh *GithubClient) FetchReleaseURL(ctx context.Context, owner, repo, tag, assetName string) (string, error) {
-	var err error
-
-	cacheKey := fmt.Sprintf("%s/%s/%s/%s", owner, repo, tag, assetName)
-	cached, err := gh.cache.Get(cacheKey)
-	if cached != "" || err != nil {
-		return cached, err
-	}
-
-	var assets releaseAssetNodes
-	var currLimit rateLimit
-	if tag == "latest" {
-		assets, currLimit, err = gh.fetchLatestRelease(ctx, owner, repo, assetName)
+func (gh *GithubClient) logRateLimit(api string, currLimit rateLimit) {
+	if currLimit.Limit > 0 && currLimit.Remaining < reservedPoints {
+		gh.logger.Crit("almost no points remaining", "api", api, "limit", currLimit.Limit, "cost", currLimit.Cost, "remaining", currLimit.Remaining, "resetAt", currLimit.ResetAt)
 	} else {
-		assets, currLimit, err = gh.fetchSpecificTag(ctx, owner, repo, tag, assetName)
+		gh.logger.Info("current rate limit points", "api", api, "limit", currLimit.Limit, "cost", currLimit.Cost, "remaining", currLimit.Remaining, "resetAt", currLimit.ResetAt)
 	}
+}
 
-	if currLimit.Limit > 0 && currLimit.Remaining < 50 {
-		gh.logger.Crit("almost no points remaining", "limit", currLimit.Limit, "cost", currLimit.Cost, "remaining", currLimit.Remaining, "resetAt", currLimit.ResetAt)
-	} else {
-		gh.logger.Info("current rate limit points", "limit", currLimit.Limit, "cost", currLimit.Cost, "remaining", currLimit.Remaining, "resetAt", currLimit.ResetAt)
+// ResolveRelease implements `ReleaseProvider`.
+//
+// In `ModeAuto`, server errors of the GraphQL API are retried using the REST API.
+func (gh *GithubClient) ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	api, backend := gh.backend()
+	release, currLimit, err := gh.search.resolve(ctx, backend, owner, repo, tag, pattern)
+	gh.logRateLimit(api, currLimit)
+	if api == "graphql" {
+		if t, ok := err.(GitHubError); ok && (t.Type == TypeUpstream || t.Type == TypeTimeout || t.Type == TypeRateLimited) && gh.mode == ModeAuto && ctx.Err() == nil {
+			gh.logger.Warn("graphql api failed, falling back to rest api", "err", err)
+			release, currLimit, err = gh.search.resolve(ctx, breakerBackend{gh.rest, &gh.restBreaker}, owner, repo, tag, pattern)
+			gh.logRateLimit("rest", currLimit)
+		}
 	}
 
-	if err != nil {
-		gh.cache.Put(cacheKey, "", err)
-		return "", err
-	}
+	return release, err
+}
 
-	if len(assets) == 0 {
-		gh.cache.Put(cacheKey, "", errAssetNotFound)
-		return "", errAssetNotFound
-	}
+// circuits implements `circuitReporter`.
+func (gh *GithubClient) circuits() map[string]*circuitBreaker {
+	return map[string]*circuitBreaker{"graphql": &gh.graphqlBreaker, "rest": &gh.restBreaker}
+}
 
-	url := assets[0].DownloadUrl
-	gh.cache.Put(cacheKey, url, nil)
+// SetAPIMode configures which of GitHub's APIs is used.
+func (gh *GithubClient) SetAPIMode(mode APIMode) {
+	gh.mode = mode
+}
 
-	return url, nil
+// SetSearchLimits configures how many releases are inspected at most and how many rate limit points may be spent
+// when paging through the releases of a repository.
+func (gh *GithubClient) SetSearchLimits(depth, costBudget int) {
+	gh.search.depth = depth
+	gh.search.costBudget = costBudget
 }
 
-// NewOauthClient creates an oauth2 client with a static token source to use with GitHub's personal access tokens.
+// NewOauthClient creates an oauth2 client with a static token source to use with GitHub's or GitLab's personal
+// access tokens.
 func NewOauthClient(ctx context.Context, token string) *http.Client {
 	src := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
@@ -190,15 +250,28 @@ func NewOauthClient(ctx context.Context, token string) *http.Client {
 
 // NewGitHubClient creates a GithubClient "enterprise" instance using an established oauth2 HTTP client.
 //
+// The url is the one of the GraphQL API, the URL of the REST API is derived from it.
 // The url and httpClient are parameters mainly for proper testing purposes.
 func NewGitHubClient(url string, httpClient *http.Client, cache Cacher, logger log.Logger) *GithubClient {
 	gc := GithubClient{
 		httpClient: httpClient,
-		cache:      cache,
 		logger:     logger,
+		search:     releaseSearch{depth: defaultSearchDepth, costBudget: defaultCostBudget, logger: logger},
 	}
+	gc.releaseResolver = newReleaseResolver("gh", &gc, cache)
 
-	gc.client = githubv4.NewEnterpriseClient(url, gc.httpClient)
+	gc.graphql = newGraphqlBackend(url, gc.httpClient)
+	gc.rest = newRestBackend(url, gc.httpClient)
 
 	return &gc
 }
+
+// NewGitHubEnterpriseClient creates a GithubClient for the GitHub Enterprise Server `host` whose GraphQL API is
+// located at `url`, e.g. `https://host/api/graphql`.
+//
+// Results are cached in a namespace of their own for every host.
+func NewGitHubEnterpriseClient(host, url string, httpClient *http.Client, cache Cacher, logger log.Logger) *GithubClient {
+	gc := NewGitHubClient(url, httpClient, cache, logger)
+	gc.namespace = "ghe/" + host
+	return gc
+}
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 )
@@ -22,10 +25,10 @@ func discardLogger() log.Logger {
 
 type NoopCache struct{}
 
-func (nc *NoopCache) Put(k, v string, err error) {
+func (nc *NoopCache) Put(k, v string, err error, ttl time.Duration) {
 }
-func (nc *NoopCache) Get(k string) (v string, err error) {
-	return "", nil
+func (nc *NoopCache) Get(k string) (v string, stale bool, err error) {
+	return "", false, nil
 }
 
 func testingHTTPClient(handler http.Handler) (*httptest.Server, func()) {
@@ -157,3 +160,676 @@ func TestGithubClient_FetchReleaseURL_Tag(t *testing.T) {
 		})
 	}
 }
+
+var fetchReleaseURLResponsesRange = map[string]struct {
+	Tag         string
+	TagExists   bool
+	ReturnValue string
+	ReturnError error
+}{
+	"caret": {
+		Tag:         "^1.4",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.5.1/testing.zip",
+	},
+	"tilde": {
+		Tag:         "~1.4",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.4.0/testing.zip",
+	},
+	"range": {
+		Tag:         ">=1.2 <2",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.5.1/testing.zip",
+	},
+	"wildcard": {
+		Tag:         "1.x",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.5.1/testing.zip",
+	},
+	"wildcard tag": {
+		Tag:         "1.x",
+		TagExists:   true,
+		ReturnValue: "https://example.com/testing/testing/releases/download/sometag/testing.zip",
+	},
+	"release not found": {
+		Tag:         "^3",
+		ReturnError: errReleaseNotFound,
+	},
+	"asset not found": {
+		Tag:         "~1.3",
+		ReturnError: errAssetNotFound,
+	},
+}
+
+func TestVersionConstraint(t *testing.T) {
+	for tag, isRange := range map[string]bool{
+		"^1.4":      true,
+		"~2.3":      true,
+		">=1.2 <2":  true,
+		">=1.2,<2":  true,
+		"1.2 - 1.4": true,
+		"^1 || ^2":  true,
+		"1.x":       true,
+		"v1.2.*":    true,
+		"1.2":       false,
+		"=1.2":      false,
+		"v1.2.3":    false,
+		"x":         false,
+		"*":         false,
+		"latest-v1": false,
+	} {
+		if _, ok := versionConstraint(tag); ok != isRange {
+			t.Errorf("%s: expected range %v, got %v", tag, isRange, ok)
+		}
+	}
+}
+
+func TestGithubClient_FetchReleaseURL_Range(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesRange {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					panic(err)
+				}
+				fileName := "ok_asset_found_range_page1.json"
+				if strings.Contains(string(body), "Y3Vyc29yOnYyOjU=") {
+					fileName = "ok_asset_found_range_page2.json"
+				}
+				if strings.Contains(string(body), "release(tagName") {
+					fileName = "error_release_not_found_tag.json"
+					if data.TagExists {
+						fileName = "ok_asset_found_tag.json"
+					}
+				}
+				file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", data.Tag, "testing.zip")
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
+
+var fetchReleaseURLResponsesPattern = map[string]struct {
+	AssetName   string
+	ReturnValue string
+	ReturnError error
+}{
+	"glob": {
+		AssetName:   "testing_*_linux_amd64.tar.gz",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.4.2/testing_1.4.2_linux_amd64.tar.gz",
+	},
+	"version placeholder": {
+		AssetName:   "testing_{version}_darwin_amd64.tar.gz",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.4.2/testing_1.4.2_darwin_amd64.tar.gz",
+	},
+	"tag placeholder": {
+		AssetName:   "testing_{tag}_darwin_amd64.tar.gz",
+		ReturnError: errAssetNotFound,
+	},
+	"multiple matches": {
+		AssetName:   "testing_*_linux_*.tar.gz",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.4.2/testing_1.4.2_linux_amd64.tar.gz",
+	},
+	"no match": {
+		AssetName:   "*.exe",
+		ReturnError: errAssetNotFound,
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_Pattern(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesPattern {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				file, err := os.Open(filepath.Join("test", "fixtures", "ok_asset_pattern_tag.json"))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "v1.4.2", data.AssetName)
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
+
+var fetchReleaseURLResponsesChannels = map[string]struct {
+	Tag         string
+	ReturnValue string
+}{
+	"stable": {
+		Tag:         "latest",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"prerelease": {
+		Tag:         "latest-prerelease",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.2.0-rc.1/testing.zip",
+	},
+	"any": {
+		Tag:         "latest-any",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.2.0-rc.1/testing.zip",
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_Channels(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesChannels {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				file, err := os.Open(filepath.Join("test", "fixtures", "ok_asset_found_channels.json"))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", data.Tag, "testing.zip")
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+var fetchReleaseURLResponsesPaging = map[string]struct {
+	SearchDepth int
+	CostBudget  int
+	ReturnValue string
+	ReturnError error
+}{
+	"asset on second page": {
+		SearchDepth: defaultSearchDepth,
+		CostBudget:  defaultCostBudget,
+		ReturnValue: "https://example.com/testing/testing/releases/download/v5.0.0/testing.zip",
+	},
+	"search depth reached": {
+		SearchDepth: 2,
+		CostBudget:  defaultCostBudget,
+		ReturnError: errAssetNotFound,
+	},
+	"cost budget spent": {
+		SearchDepth: defaultSearchDepth,
+		CostBudget:  1,
+		ReturnError: errAssetNotFound,
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_Paging(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesPaging {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					panic(err)
+				}
+				fileName := "ok_asset_found_latest_page1.json"
+				if strings.Contains(string(body), "Y3Vyc29yOnYyOjI=") {
+					fileName = "ok_asset_found_latest_page2.json"
+				}
+				file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+			gh.SetSearchLimits(data.SearchDepth, data.CostBudget)
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "latest-any", "testing.zip")
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
+
+var fetchReleaseURLResponsesSourceArchive = map[string]struct {
+	AssetName   string
+	ReturnValue string
+}{
+	"tarball": {
+		AssetName:   "source.tar.gz",
+		ReturnValue: "https://example.com/testing/testing/archive/sometag.tar.gz",
+	},
+	"zipball": {
+		AssetName:   "source.zip",
+		ReturnValue: "https://example.com/testing/testing/archive/sometag.zip",
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_SourceArchive(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesSourceArchive {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				file, err := os.Open(filepath.Join("test", "fixtures", "ok_source_archive_tag.json"))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", data.AssetName)
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+var restFixtures = map[string]string{
+	"/repos/testing/testing/releases/tags/sometag": "rest_release_tag.json",
+	"/repos/testing/testing/releases/latest":       "rest_release_latest.json",
+	"/repos/testing/testing/releases":              "rest_releases.json",
+}
+
+func restHandler(graphqlStatus int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(graphqlStatus)
+			return
+		}
+		fileName, ok := restFixtures[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fileName = "rest_error_not_found.json"
+		}
+		file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.Copy(w, file)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+var fetchReleaseURLResponsesREST = map[string]struct {
+	Repo        string
+	Tag         string
+	AssetName   string
+	ReturnValue string
+	ReturnError error
+}{
+	"tag": {
+		Repo:        "testing",
+		Tag:         "sometag",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://example.com/testing/testing/releases/download/sometag/testing.zip",
+	},
+	"release not found": {
+		Repo:        "testing",
+		Tag:         "othertag",
+		AssetName:   "testing.zip",
+		ReturnError: errReleaseNotFound,
+	},
+	"asset not found": {
+		Repo:        "testing",
+		Tag:         "sometag",
+		AssetName:   "testing.tar.gz",
+		ReturnError: errAssetNotFound,
+	},
+	"latest": {
+		Repo:        "testing",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"latest prerelease": {
+		Repo:        "testing",
+		Tag:         "latest-prerelease",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.1.0-rc.1/testing.zip",
+	},
+	"range": {
+		Repo:        "testing",
+		Tag:         "~1.0",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://example.com/testing/testing/releases/download/v1.0.0/testing.zip",
+	},
+	"source archive": {
+		Repo:        "testing",
+		Tag:         "sometag",
+		AssetName:   "source.tar.gz",
+		ReturnValue: "https://api.example.com/repos/testing/testing/tarball/sometag",
+	},
+	"repo not found": {
+		Repo:        "other",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnError: errors.New("Could not resolve to a Repository with the name 'testing/other'."),
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_REST(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesREST {
+		t.Run(name, func(t *testing.T) {
+			httpServer, teardown := testingHTTPClient(restHandler(http.StatusOK))
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+			gh.SetAPIMode(ModeREST)
+
+			url, err := gh.FetchReleaseURL(context.Background(), "testing", data.Repo, data.Tag, data.AssetName)
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
+
+func TestGithubClient_FetchReleaseURL_RESTFallback(t *testing.T) {
+	httpServer, teardown := testingHTTPClient(restHandler(http.StatusBadGateway))
+	defer teardown()
+
+	cache := NoopCache{}
+	gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+	expected := "https://example.com/testing/testing/releases/download/sometag/testing.zip"
+	url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+	if url != expected || err != nil {
+		t.Errorf("expected fallback to REST API, got url '%s' and err '%v'", url, err)
+	}
+
+	gh.SetAPIMode(ModeGraphQL)
+	_, err = gh.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+	if ghErr, ok := err.(GitHubError); !ok || ghErr.Type != TypeUpstream {
+		t.Errorf("expected server error without fallback, got '%v'", err)
+	}
+}
+
+var fetchReleaseURLResponsesErrorTypes = map[string]struct {
+	Status    int
+	Header    map[string]string
+	FileName  string
+	ErrorType GitHubErrorType
+}{
+	"not found": {
+		Status:    http.StatusOK,
+		FileName:  "error_repo_not_found.json",
+		ErrorType: TypeNotFound,
+	},
+	"forbidden": {
+		Status:    http.StatusOK,
+		FileName:  "error_forbidden.json",
+		ErrorType: TypeForbidden,
+	},
+	"rate limited": {
+		Status:    http.StatusOK,
+		FileName:  "error_rate_limited.json",
+		ErrorType: TypeRateLimited,
+	},
+	"secondary rate limit": {
+		Status:    http.StatusForbidden,
+		Header:    map[string]string{"Retry-After": "60"},
+		FileName:  "error_bad_credentials.json",
+		ErrorType: TypeRateLimited,
+	},
+	"bad credentials": {
+		Status:    http.StatusUnauthorized,
+		FileName:  "error_bad_credentials.json",
+		ErrorType: TypeUnauthorized,
+	},
+	"gateway timeout": {
+		Status:    http.StatusGatewayTimeout,
+		FileName:  "error_bad_credentials.json",
+		ErrorType: TypeTimeout,
+	},
+	"server error": {
+		Status:    http.StatusInternalServerError,
+		FileName:  "error_bad_credentials.json",
+		ErrorType: TypeUpstream,
+	},
+}
+
+func TestGithubClient_FetchReleaseURL_ErrorTypes(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesErrorTypes {
+		t.Run(name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range data.Header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(data.Status)
+				file, err := os.Open(filepath.Join("test", "fixtures", data.FileName))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+			gh.SetAPIMode(ModeGraphQL)
+
+			_, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+			if ghErr, ok := err.(GitHubError); !ok || ghErr.Type != data.ErrorType {
+				t.Errorf("error type does not match. Expected: %d, got '%#v'", data.ErrorType, err)
+			}
+		})
+	}
+}
+
+func TestGithubClient_FetchReleaseURL_EnterpriseHosts(t *testing.T) {
+	fixtureHandler := func(fileName string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+			if err != nil {
+				panic(err)
+			}
+			_, err = io.Copy(w, file)
+			if err != nil {
+				panic(err)
+			}
+		})
+	}
+	foundServer, teardownFound := testingHTTPClient(fixtureHandler("ok_asset_found_tag.json"))
+	defer teardownFound()
+	notFoundServer, teardownNotFound := testingHTTPClient(fixtureHandler("error_release_not_found_tag.json"))
+	defer teardownNotFound()
+
+	// both hosts share the cache, the lookups must not interfere.
+	cache := NewCache(10, 0, time.Minute, time.Minute)
+	found := NewGitHubEnterpriseClient("ghe1.example.com", foundServer.URL, http.DefaultClient, cache, discardLogger())
+	notFound := NewGitHubEnterpriseClient("ghe2.example.com", notFoundServer.URL, http.DefaultClient, cache, discardLogger())
+
+	expected := "https://example.com/testing/testing/releases/download/sometag/testing.zip"
+	url, err := found.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+	if url != expected || err != nil {
+		t.Errorf("expected '%s', got url '%s' and err '%v'", expected, url, err)
+	}
+	url, err = notFound.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+	if url != "" || err != errReleaseNotFound {
+		t.Errorf("expected release not found, got url '%s' and err '%v'", url, err)
+	}
+}
+
+func TestGithubClient_FetchReleaseURL_CircuitBreaker(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	requests := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// the query spends the last point.
+		fmt.Fprintf(w, `{"data": {"repository": {"release": {"releaseAssets": {"nodes": [{"downloadUrl": "https://example.com/testing.zip"}]}}}, "rateLimit": {"limit": 5000, "cost": 1, "remaining": 0, "resetAt": %q}}}`, resetAt.Format(time.RFC3339))
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	cache := NewCache(10, 0, time.Minute, time.Minute)
+	gh := NewGitHubClient(httpServer.URL, http.DefaultClient, cache, discardLogger())
+	gh.SetAPIMode(ModeGraphQL)
+
+	if _, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "v1", "testing.zip"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the circuit is open, uncached lookups fail without a request.
+	_, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "v2", "testing.zip")
+	ghErr, ok := err.(GitHubError)
+	if !ok || ghErr.Type != TypeRateLimited || !ghErr.WrappedError.(rateLimitedError).ResetAt.Equal(resetAt) {
+		t.Errorf("expected rate limited error until %s, got '%v'", resetAt, err)
+	}
+	if requests != 1 {
+		t.Errorf("expected a single request, got %d", requests)
+	}
+
+	// cached lookups are still served.
+	if url, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "v1", "testing.zip"); url != "https://example.com/testing.zip" || err != nil {
+		t.Errorf("expected cached url, got '%s' and err '%v'", url, err)
+	}
+
+	state := gh.graphqlBreaker.report("gh/graphql")
+	if !state.Open || !state.ResetAt.Equal(resetAt) {
+		t.Errorf("expected open circuit until %s, got %+v", resetAt, state)
+	}
+}
+
+func TestCircuitBreaker_Observe(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour)
+	for name, data := range map[string]struct {
+		Limit rateLimit
+		Open  bool
+	}{
+		"exhausted":        {Limit: rateLimit{Limit: 5000, Cost: 1, Remaining: 0, ResetAt: resetAt}, Open: true},
+		"accumulated cost": {Limit: rateLimit{Limit: 60, Cost: 2, Remaining: 1, ResetAt: resetAt}, Open: false},
+		"points left":      {Limit: rateLimit{Limit: 5000, Cost: 1, Remaining: 10, ResetAt: resetAt}, Open: false},
+		"no rate limit":    {Limit: rateLimit{Cost: 1}, Open: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cb circuitBreaker
+			cb.observe(data.Limit, nil)
+			if open, _ := cb.state(); open != data.Open {
+				t.Errorf("expected open %v, got %v", data.Open, open)
+			}
+		})
+	}
+}
+
+func TestGithubClient_FetchReleaseURL_Retry(t *testing.T) {
+	for name, data := range map[string]struct {
+		Failures int
+		Status   int
+		Requests int
+		Error    bool
+	}{
+		"recovered server error":  {Failures: 2, Status: http.StatusBadGateway, Requests: 3},
+		"persistent server error": {Failures: 5, Status: http.StatusBadGateway, Requests: 3, Error: true},
+		"not found":               {Failures: 5, Status: http.StatusNotFound, Requests: 1, Error: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= data.Failures {
+					w.WriteHeader(data.Status)
+					return
+				}
+				file, err := os.Open(filepath.Join("test", "fixtures", "ok_asset_found_tag.json"))
+				if err != nil {
+					panic(err)
+				}
+				_, err = io.Copy(w, file)
+				if err != nil {
+					panic(err)
+				}
+			})
+			httpServer, teardown := testingHTTPClient(h)
+			defer teardown()
+
+			cache := NoopCache{}
+			gh := NewGitHubClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+			gh.SetAPIMode(ModeGraphQL)
+			gh.graphql.retry = retryPolicy{attempts: 3, base: time.Millisecond, max: 10 * time.Millisecond}
+
+			_, err := gh.FetchReleaseURL(context.Background(), "testing", "testing", "sometag", "testing.zip")
+			if (err != nil) != data.Error {
+				t.Errorf("unexpected error '%v'", err)
+			}
+			if requests != data.Requests {
+				t.Errorf("expected %d requests, got %d", data.Requests, requests)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Deadline(t *testing.T) {
+	rp := retryPolicy{attempts: 3, base: time.Second, max: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if rp.wait(ctx, time.Second) {
+		t.Error("expected the retry to be abandoned")
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("expected to give up without waiting")
+	}
+}
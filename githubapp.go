@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appJWTLifetime is the lifetime of the JWT used to authenticate as GitHub App, GitHub allows at most 10 minutes.
+	appJWTLifetime = 9 * time.Minute
+	// installationTokenRefresh is the time before its expiry an installation access token is refreshed.
+	installationTokenRefresh = 5 * time.Minute
+	// installationTokenTimeout limits the time spent creating an installation access token. Lookups wait for the
+	// token while it is created, a hanging request would block all of them.
+	installationTokenTimeout = 10 * time.Second
+)
+
+// ParsePrivateKey parses the PEM encoded private key of a GitHub App. Both PKCS#1 as generated by GitHub and PKCS#8
+// are supported.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("githubapp: no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("githubapp: private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// appTokenSource exchanges a JWT signed by the private key of a GitHub App for installation access tokens.
+type appTokenSource struct {
+	ctx            context.Context
+	apiURL         string
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+	httpClient     *http.Client
+	timeout        time.Duration
+}
+
+// jwt mints a JWT (RS256) identifying the GitHub App. The issue time is set in the past to allow for clock drift.
+func (s *appTokenSource) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(s.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Token implements `oauth2.TokenSource`. The expiry is shortened so that the token gets refreshed in time.
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", s.apiURL, s.installationID)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	resp, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("githubapp: creating installation access token failed: %s", resp.Status)
+	}
+
+	var out struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: out.Token,
+		TokenType:   "token",
+		Expiry:      out.ExpiresAt.Add(-installationTokenRefresh),
+	}, nil
+}
+
+// NewGitHubAppClient creates an HTTP client authenticating as installation `installationID` of the GitHub App `appID`.
+// Installation access tokens are created using `httpClient` and the REST API at `apiURL` and refreshed before they
+// expire.
+func NewGitHubAppClient(ctx context.Context, apiURL string, appID, installationID int64, key *rsa.PrivateKey, httpClient *http.Client) *http.Client {
+	src := &appTokenSource{
+		ctx:            ctx,
+		apiURL:         apiURL,
+		appID:          appID,
+		installationID: installationID,
+		key:            key,
+		httpClient:     httpClient,
+		timeout:        installationTokenTimeout,
+	}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, src))
+}
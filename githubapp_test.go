@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyJWT checks the signature of an RS256 JWT and returns its claims.
+func verifyJWT(token string, key *rsa.PublicKey) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt '%s'", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	err = json.Unmarshal(payload, &claims)
+	return claims, err
+}
+
+func TestGitHubAppClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if err != nil {
+		t.Fatalf("unexpected error parsing private key: %v", err)
+	}
+
+	issued := 0
+	var authorizations []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/installations/42/access_tokens" {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			return
+		}
+		claims, err := verifyJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), &key.PublicKey)
+		if err != nil || claims["iss"] != "1337" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		issued++
+		// expires within the refresh margin, hence a new token is needed for every request.
+		expiresAt := time.Now().Add(installationTokenRefresh / 2).UTC().Format(time.RFC3339)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "installation-token-%d", "expires_at": "%s"}`, issued, expiresAt)
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	client := NewGitHubAppClient(context.Background(), httpServer.URL, 1337, 42, parsed, httpServer.Client())
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(httpServer.URL+"/graphql", "application/json", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	expected := []string{"token installation-token-1", "token installation-token-2"}
+	if fmt.Sprint(authorizations) != fmt.Sprint(expected) {
+		t.Errorf("authorizations do not match. Expected: %v, got %v", expected, authorizations)
+	}
+}
+
+func TestAppTokenSource_Failures(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]http.HandlerFunc{
+		"unauthorized": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+		"hanging": func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		},
+		"malformed": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"token": `)
+		},
+	}
+	for name, h := range tests {
+		t.Run(name, func(t *testing.T) {
+			accept := make(chan string, 1)
+			httpServer, teardown := testingHTTPClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accept <- r.Header.Get("Accept")
+				h(w, r)
+			}))
+			defer teardown()
+
+			src := &appTokenSource{
+				ctx:            context.Background(),
+				apiURL:         httpServer.URL,
+				appID:          1337,
+				installationID: 42,
+				key:            key,
+				httpClient:     httpServer.Client(),
+				timeout:        50 * time.Millisecond,
+			}
+			start := time.Now()
+			if token, err := src.Token(); err == nil {
+				t.Errorf("expected an error, got token %v", token)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the request to time out, took %v", elapsed)
+			}
+			if a := <-accept; a != "application/vnd.github+json" {
+				t.Errorf("unexpected Accept header '%s'", a)
+			}
+		})
+	}
+}
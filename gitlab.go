@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// GitlabClient resolves releases using the Releases API of GitLab.
+type GitlabClient struct {
+	*releaseResolver
+	backend *gitlabBackend
+	logger  log.Logger
+	search  releaseSearch
+	breaker circuitBreaker
+}
+
+// gitlabBackend retrieves releases using GitLab's REST API v4.
+type gitlabBackend struct {
+	jsonAPI
+}
+
+type gitlabRelease struct {
+	TagName         string `json:"tag_name"`
+	UpcomingRelease bool   `json:"upcoming_release"`
+	Assets          struct {
+		Sources []struct {
+			Format string `json:"format"`
+			URL    string `json:"url"`
+		} `json:"sources"`
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// node converts the release into the representation used by GitHub's GraphQL API.
+// Upcoming releases, whose release date lies in the future, are treated as prereleases.
+func (r gitlabRelease) node(pattern assetPattern) releaseNode {
+	node := releaseNode{
+		TagName:      r.TagName,
+		IsPrerelease: r.UpcomingRelease,
+	}
+	for _, source := range r.Assets.Sources {
+		switch source.Format {
+		case "tar.gz":
+			node.TarballUrl = source.URL
+		case "zip":
+			node.ZipballUrl = source.URL
+		}
+	}
+	for _, link := range r.Assets.Links {
+		if pattern.isLiteral() && link.Name != string(pattern) {
+			continue
+		}
+		downloadURL := link.DirectAssetURL
+		if downloadURL == "" {
+			downloadURL = link.URL
+		}
+		node.ReleaseAssets.Nodes = append(node.ReleaseAssets.Nodes, releaseAsset{Name: link.Name, DownloadUrl: downloadURL})
+	}
+	return node
+}
+
+// gitlabRateLimit reads the rate limit headers. Every request costs a single point.
+func gitlabRateLimit(h http.Header) rateLimit {
+	limit, _ := strconv.Atoi(h.Get("RateLimit-Limit"))
+	remaining, _ := strconv.Atoi(h.Get("RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(h.Get("RateLimit-Reset"), 10, 64)
+	return rateLimit{Limit: limit, Cost: 1, Remaining: remaining, ResetAt: time.Unix(reset, 0)}
+}
+
+// gitlabNextPage reads the number of the following page from the `X-Next-Page` header.
+func gitlabNextPage(h http.Header, page, n, first int) string {
+	return h.Get("X-Next-Page")
+}
+
+// projectPath returns the API path of the project `namespace/project`. The namespace may contain nested groups,
+// the whole path is encoded as project ID.
+func projectPath(namespace, project string) string {
+	return "/projects/" + url.PathEscape(namespace+"/"+project)
+}
+
+func (gb *gitlabBackend) releaseByTag(ctx context.Context, namespace, project, tag string, pattern assetPattern) (*releaseNode, rateLimit, error) {
+	var release gitlabRelease
+	found, _, limit, err := gb.get(ctx, projectPath(namespace, project)+"/releases/"+url.PathEscape(tag), &release)
+	if err != nil || !found {
+		return nil, limit, err
+	}
+	node := release.node(pattern)
+	return &node, limit, nil
+}
+
+// releases returns a page of releases sorted by their release date, newest first. GitLab has no concept of a
+// "Latest" release, therefore `Latest` is never set.
+func (gb *gitlabBackend) releases(ctx context.Context, namespace, project string, pattern assetPattern, first int, cursor string) (releasePage, rateLimit, error) {
+	var releases []gitlabRelease
+	path := projectPath(namespace, project) + "/releases?order_by=released_at&sort=desc"
+	found, next, limit, err := gb.page(ctx, path, first, cursor, &releases)
+	if err != nil {
+		return releasePage{}, limit, err
+	}
+	if !found {
+		return releasePage{}, limit, NewGitHubError(fmt.Sprintf("gitlab: project '%s/%s' not found", namespace, project), TypeNotFound)
+	}
+
+	page := releasePage{Next: next}
+	for _, release := range releases {
+		page.Releases = append(page.Releases, release.node(pattern))
+	}
+	return page, limit, nil
+}
+
+// ResolveRelease implements `ReleaseProvider`.
+func (gl *GitlabClient) ResolveRelease(ctx context.Context, namespace, project, tag string, pattern assetPattern) (resolvedRelease, error) {
+	release, currLimit, err := gl.search.resolve(ctx, breakerBackend{gl.backend, &gl.breaker}, namespace, project, tag, pattern)
+	if currLimit.Limit > 0 && currLimit.Remaining < reservedPoints {
+		gl.logger.Crit("almost no points remaining", "limit", currLimit.Limit, "cost", currLimit.Cost, "remaining", currLimit.Remaining, "resetAt", currLimit.ResetAt)
+	}
+	return release, err
+}
+
+// circuits implements `circuitReporter`.
+func (gl *GitlabClient) circuits() map[string]*circuitBreaker {
+	return map[string]*circuitBreaker{"rest": &gl.breaker}
+}
+
+// SetSearchLimits configures how many releases are inspected at most and how many requests may be sent
+// when paging through the releases of a project.
+func (gl *GitlabClient) SetSearchLimits(depth, costBudget int) {
+	gl.search.depth = depth
+	gl.search.costBudget = costBudget
+}
+
+// NewGitLabClient creates a GitlabClient for the API v4 at `url`, e.g. `https://gitlab.com/api/v4`.
+//
+// Private projects require an httpClient authenticating using an access token, see `NewOauthClient`.
+func NewGitLabClient(url string, httpClient *http.Client, cache Cacher, logger log.Logger) *GitlabClient {
+	gl := GitlabClient{
+		backend: &gitlabBackend{jsonAPI{name: "gitlab", baseURL: url, httpClient: httpClient, rateLimit: gitlabRateLimit, pageSize: "per_page", nextPage: gitlabNextPage}},
+		logger:  logger,
+		search:  releaseSearch{depth: defaultSearchDepth, costBudget: defaultCostBudget, logger: logger},
+	}
+	gl.releaseResolver = newReleaseResolver("gl", &gl, cache)
+
+	return &gl
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var gitlabFixtures = map[string]string{
+	"/projects/group%2Fsubgroup%2Ftesting/releases/v1.0.0": "gitlab_release_tag.json",
+	"/projects/group%2Fsubgroup%2Ftesting/releases":        "gitlab_releases.json",
+}
+
+func gitlabHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileName, ok := gitlabFixtures[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fileName = "gitlab_error_not_found.json"
+		}
+		file, err := os.Open(filepath.Join("test", "fixtures", fileName))
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.Copy(w, file)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+var fetchReleaseURLResponsesGitLab = map[string]struct {
+	Project     string
+	Tag         string
+	AssetName   string
+	ReturnValue string
+	ReturnError error
+}{
+	"tag": {
+		Project:     "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitlab.example.com/group/subgroup/testing/-/releases/v1.0.0/downloads/testing.zip",
+	},
+	"release not found": {
+		Project:     "testing",
+		Tag:         "v0.9.0",
+		AssetName:   "testing.zip",
+		ReturnError: errReleaseNotFound,
+	},
+	"asset not found": {
+		Project:     "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "testing.tar.gz",
+		ReturnError: errAssetNotFound,
+	},
+	"latest": {
+		Project:     "testing",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitlab.example.com/group/subgroup/testing/-/releases/v1.0.0/downloads/testing.zip",
+	},
+	"latest upcoming": {
+		Project:     "testing",
+		Tag:         "latest-any",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitlab.example.com/group/subgroup/testing/-/releases/v1.1.0/downloads/testing.zip",
+	},
+	"range": {
+		Project:     "testing",
+		Tag:         "~1.0",
+		AssetName:   "testing.zip",
+		ReturnValue: "https://gitlab.example.com/group/subgroup/testing/-/releases/v1.0.0/downloads/testing.zip",
+	},
+	"source archive": {
+		Project:     "testing",
+		Tag:         "v1.0.0",
+		AssetName:   "source.tar.gz",
+		ReturnValue: "https://gitlab.example.com/group/subgroup/testing/-/archive/v1.0.0/testing-v1.0.0.tar.gz",
+	},
+	"project not found": {
+		Project:     "other",
+		Tag:         "latest",
+		AssetName:   "testing.zip",
+		ReturnError: errors.New("gitlab: project 'group/subgroup/other' not found"),
+	},
+}
+
+func TestGitlabClient_FetchReleaseURL(t *testing.T) {
+	for name, data := range fetchReleaseURLResponsesGitLab {
+		t.Run(name, func(t *testing.T) {
+			httpServer, teardown := testingHTTPClient(gitlabHandler())
+			defer teardown()
+
+			cache := NoopCache{}
+			gl := NewGitLabClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+
+			url, err := gl.FetchReleaseURL(context.Background(), "group/subgroup", data.Project, data.Tag, data.AssetName)
+			if url != data.ReturnValue {
+				t.Errorf("url does not match. Expected: '%s', got '%s'", data.ReturnValue, url)
+			}
+			if fmt.Sprintf("%s", err) != fmt.Sprintf("%s", data.ReturnError) {
+				t.Errorf("err does not match. Expected: '%v', got '%v'", data.ReturnError, err)
+			}
+		})
+	}
+}
@@ -1,7 +1,12 @@
 module github.com/mweibel/gitreleases
 
 require (
+	github.com/Masterminds/semver/v3 v3.1.1
+	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
+	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/gomodule/redigo v1.7.0 // indirect
 	github.com/gorilla/mux v1.7.0
 	github.com/inconshreveable/log15 v0.0.0-20180818164646-67afb5ed74ec
 	github.com/mattn/go-colorable v0.1.1 // indirect
@@ -10,6 +15,8 @@ require (
 	github.com/rakyll/statik v0.1.5
 	github.com/shurcooL/githubv4 v0.0.0-20190119021625-d9689b595017
 	github.com/shurcooL/graphql v0.0.0-20181231061246-d48a9a75455f // indirect
+	github.com/yuin/gopher-lua v0.0.0-20180827083657-b942cacc89fe // indirect
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/oauth2 v0.0.0-20190226205417-e64efc72b421
 )
 
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+type fetchSpecificTag struct {
+	Repository struct {
+		Release *releaseNode `graphql:"release(tagName: $tag)"`
+	} `graphql:"repository(owner: $owner, name: $repo)"`
+	RateLimit rateLimit
+}
+
+type fetchReleases struct {
+	Repository struct {
+		LatestRelease *releaseNode
+		Releases      struct {
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   githubv4.String
+			}
+			Nodes []releaseNode
+		} `graphql:"releases(first: $first, after: $cursor, orderBy: {direction: DESC, field: CREATED_AT})"`
+	} `graphql:"repository(owner: $owner, name: $repo)"`
+	RateLimit rateLimit
+}
+
+// graphqlResponse records the parts of a GraphQL response which are not exposed by the graphql library.
+type graphqlResponse struct {
+	status  int
+	header  http.Header
+	message string
+	errors  []graphqlError
+}
+
+// graphqlError is an entry of the `errors` of a GraphQL response.
+type graphqlError struct {
+	// Type classifies the error, e.g. `NOT_FOUND`.
+	Type    string
+	Message string
+	// Path locates the field which failed, starting with the top-level field or its alias.
+	Path []interface{}
+}
+
+type graphqlResponseKey struct{}
+
+// graphqlRecorder is an `http.RoundTripper` recording the response into the `graphqlResponse` of the request context.
+type graphqlRecorder struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (gr graphqlRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := gr.base.RoundTrip(r)
+	record, ok := r.Context().Value(graphqlResponseKey{}).(*graphqlResponse)
+	if err != nil || !ok {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var parsed struct {
+		Message string
+		Errors  []graphqlError
+	}
+	json.Unmarshal(body, &parsed)
+	record.status = resp.StatusCode
+	record.header = resp.Header
+	record.message = parsed.Message
+	record.errors = parsed.Errors
+	return resp, nil
+}
+
+// retryReason returns why the query failing with `err` may be retried, an empty string if it must not be retried.
+// Only transport errors, server errors and secondary rate limits, which ask to retry after a short while, are
+// considered transient.
+func (record *graphqlResponse) retryReason(err GitHubError) string {
+	switch {
+	case record.status == 0 && (err.Type == TypeUpstream || err.Type == TypeTimeout):
+		return "transport"
+	case record.status >= http.StatusInternalServerError:
+		return "server_error"
+	case err.Type == TypeRateLimited && record.header.Get("Retry-After") != "":
+		return "secondary_rate_limit"
+	}
+	return ""
+}
+
+// parseGraphqlError translates between the opaque error type of the graphql library and our own using the recorded
+// response. The message of the first error is kept, it is classified by the HTTP status code or the type of the
+// first error.
+func parseGraphqlError(err error, record *graphqlResponse) GitHubError {
+	if record.status == 0 {
+		return transportError(err)
+	}
+	if record.status != http.StatusOK {
+		return statusError(err, record.status, record.header, record.message)
+	}
+	var first graphqlError
+	if len(record.errors) > 0 {
+		first = record.errors[0]
+	}
+	return classifyGraphqlError(err, first, record.header)
+}
+
+// classifyGraphqlError classifies `err`, which describes the GraphQL error `e`, by the type of `e`.
+func classifyGraphqlError(err error, e graphqlError, h http.Header) GitHubError {
+	switch e.Type {
+	case "NOT_FOUND":
+		return GitHubError{err, TypeNotFound}
+	case "RATE_LIMITED":
+		return GitHubError{rateLimitedError{ResetAt: rateLimitReset(h)}, TypeRateLimited}
+	case "FORBIDDEN":
+		return GitHubError{err, TypeForbidden}
+	}
+	// older GitHub Enterprise Server versions do not return error types.
+	if strings.Contains(err.Error(), "Could not resolve to") {
+		return GitHubError{err, TypeNotFound}
+	}
+	return GitHubError{err, TypeUpstream}
+}
+
+// graphqlBackend retrieves releases using the GraphQL API v4. It keeps track of the rate limit points
+// returned by the last query.
+type graphqlBackend struct {
+	client *githubv4.Client
+	retry  retryPolicy
+
+	l     sync.RWMutex
+	limit rateLimit
+}
+
+func newGraphqlBackend(url string, httpClient *http.Client) *graphqlBackend {
+	recordingClient := *httpClient
+	recordingClient.Transport = graphqlRecorder{base: httpClient.Transport}
+	if recordingClient.Transport.(graphqlRecorder).base == nil {
+		recordingClient.Transport = graphqlRecorder{base: http.DefaultTransport}
+	}
+	return &graphqlBackend{client: githubv4.NewEnterpriseClient(url, &recordingClient), retry: defaultRetryPolicy}
+}
+
+// query runs the GraphQL query `q` and classifies its errors. Transient failures are retried according to the retry
+// policy as long as the deadline of `ctx` permits.
+//
+// The recorded response of the last attempt is returned as well.
+func (gb *graphqlBackend) query(ctx context.Context, q interface{}, variables map[string]interface{}) (*graphqlResponse, error) {
+	for attempt := 1; ; attempt++ {
+		record := &graphqlResponse{}
+		err := gb.client.Query(context.WithValue(ctx, graphqlResponseKey{}, record), q, variables)
+		if err == nil {
+			return record, nil
+		}
+		ghErr := parseGraphqlError(err, record)
+		reason := record.retryReason(ghErr)
+		if reason == "" || attempt >= gb.retry.attempts || ctx.Err() != nil {
+			return record, ghErr
+		}
+
+		// secondary rate limits are only waited for if they are lifted shortly.
+		delay := gb.retry.backoff(attempt)
+		if limited, ok := ghErr.WrappedError.(rateLimitedError); ok && time.Until(limited.ResetAt) > delay {
+			delay = time.Until(limited.ResetAt)
+		}
+		if delay > gb.retry.max || !gb.retry.wait(ctx, delay) {
+			return record, ghErr
+		}
+		retriesCounter.WithLabelValues("graphql", reason).Inc()
+	}
+}
+
+func (gb *graphqlBackend) observe(limit rateLimit) {
+	if limit.Limit == 0 {
+		return
+	}
+	gb.l.Lock()
+	gb.limit = limit
+	gb.l.Unlock()
+}
+
+// exhausted returns true if the last query left less than `reservedPoints` and the limit has not been reset yet.
+func (gb *graphqlBackend) exhausted() bool {
+	gb.l.RLock()
+	defer gb.l.RUnlock()
+	return gb.limit.Limit > 0 && gb.limit.Remaining < reservedPoints && time.Now().Before(gb.limit.ResetAt)
+}
+
+func (gb *graphqlBackend) releaseByTag(ctx context.Context, owner, repo, tag string, pattern assetPattern) (*releaseNode, rateLimit, error) {
+	q := fetchSpecificTag{}
+	variables := map[string]interface{}{
+		"owner":     githubv4.String(owner),
+		"repo":      githubv4.String(repo),
+		"tag":       githubv4.String(tag),
+		"assetName": pattern.nameFilter(),
+	}
+
+	_, err := gb.query(ctx, &q, variables)
+	gb.observe(q.RateLimit)
+	if err != nil {
+		return nil, q.RateLimit, err
+	}
+	return q.Repository.Release, q.RateLimit, nil
+}
+
+func (gb *graphqlBackend) releases(ctx context.Context, owner, repo string, pattern assetPattern, first int, cursor string) (releasePage, rateLimit, error) {
+	q := fetchReleases{}
+	variables := map[string]interface{}{
+		"owner":     githubv4.String(owner),
+		"repo":      githubv4.String(repo),
+		"assetName": pattern.nameFilter(),
+		"first":     githubv4.Int(first),
+		"cursor":    (*githubv4.String)(nil),
+	}
+	if cursor != "" {
+		variables["cursor"] = githubv4.NewString(githubv4.String(cursor))
+	}
+
+	_, err := gb.query(ctx, &q, variables)
+	gb.observe(q.RateLimit)
+	if err != nil {
+		return releasePage{}, q.RateLimit, err
+	}
+
+	releases := q.Repository.Releases
+	page := releasePage{Releases: releases.Nodes}
+	if cursor == "" {
+		page.Latest = q.Repository.LatestRelease
+	}
+	if releases.PageInfo.HasNextPage {
+		page.Next = string(releases.PageInfo.EndCursor)
+	}
+	return page, q.RateLimit, nil
+}
+
+// batchQuery is a single lookup of a batched query, either of the release tagged `tag` or of the latest release.
+type batchQuery struct {
+	owner, repo string
+	// tag is empty to look up the release GitHub marks as "Latest".
+	tag     string
+	pattern assetPattern
+}
+
+var releaseNodeType = reflect.TypeOf(releaseNode{})
+
+// batchReleaseType returns a copy of `releaseNode` whose asset name filter is the variable `assetVar`.
+// Both types are convertible into each other.
+func batchReleaseType(assetVar string) reflect.Type {
+	fields := make([]reflect.StructField, releaseNodeType.NumField())
+	for i := range fields {
+		fields[i] = releaseNodeType.Field(i)
+		if fields[i].Name == "ReleaseAssets" {
+			fields[i].Tag = reflect.StructTag(fmt.Sprintf(`graphql:"releaseAssets(name: $%s, first: 100)"`, assetVar))
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// releasesBatch looks up the releases of all `queries` using a single query, every lookup is a repository field of
+// its own with the alias `r<index>`. Lookups which failed on their own are reported in `errs`, `err` is only set if
+// the whole query failed. A nil release indicates that it does not exist.
+func (gb *graphqlBackend) releasesBatch(ctx context.Context, queries []batchQuery) (releases []*releaseNode, errs []error, limit rateLimit, err error) {
+	variables := make(map[string]interface{}, 4*len(queries))
+	fields := make([]reflect.StructField, 0, len(queries)+1)
+	for i, query := range queries {
+		release := reflect.StructField{Name: "LatestRelease", Type: reflect.PtrTo(batchReleaseType(fmt.Sprintf("asset%d", i)))}
+		if query.tag != "" {
+			release.Name = "Release"
+			release.Tag = reflect.StructTag(fmt.Sprintf(`graphql:"release(tagName: $tag%d)"`, i))
+			variables[fmt.Sprintf("tag%d", i)] = githubv4.String(query.tag)
+		}
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("R%d", i),
+			Type: reflect.StructOf([]reflect.StructField{release}),
+			Tag:  reflect.StructTag(fmt.Sprintf(`graphql:"r%d: repository(owner: $owner%d, name: $repo%d)"`, i, i, i)),
+		})
+		variables[fmt.Sprintf("owner%d", i)] = githubv4.String(query.owner)
+		variables[fmt.Sprintf("repo%d", i)] = githubv4.String(query.repo)
+		variables[fmt.Sprintf("asset%d", i)] = query.pattern.nameFilter()
+	}
+	fields = append(fields, reflect.StructField{Name: "RateLimit", Type: reflect.TypeOf(rateLimit{})})
+
+	q := reflect.New(reflect.StructOf(fields))
+	record, err := gb.query(ctx, q.Interface(), variables)
+	limit = q.Elem().FieldByName("RateLimit").Interface().(rateLimit)
+	gb.observe(limit)
+	// errors of single lookups are located by the alias of their repository field.
+	itemErrors := make(map[string]graphqlError)
+	for _, e := range record.errors {
+		if alias, ok := pathAlias(e.Path); ok {
+			itemErrors[alias] = e
+		}
+	}
+	// any other failure, such as a response which cannot be decoded, fails the whole batch.
+	if err != nil && (record.status != http.StatusOK || len(record.errors) == 0 || len(itemErrors) != len(record.errors)) {
+		return nil, nil, limit, err
+	}
+
+	releases = make([]*releaseNode, len(queries))
+	errs = make([]error, len(queries))
+	for i := range queries {
+		if e, ok := itemErrors[fmt.Sprintf("r%d", i)]; ok {
+			errs[i] = classifyGraphqlError(errors.New(e.Message), e, record.header)
+			continue
+		}
+		if release := q.Elem().Field(i).Field(0); !release.IsNil() {
+			node := release.Elem().Convert(releaseNodeType).Interface().(releaseNode)
+			releases[i] = &node
+		}
+	}
+	return releases, errs, limit, nil
+}
+
+// pathAlias returns the top-level field of an error path.
+func pathAlias(path []interface{}) (string, bool) {
+	if len(path) == 0 {
+		return "", false
+	}
+	alias, ok := path[0].(string)
+	return alias, ok
+}
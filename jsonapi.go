@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonAPI requests the resources of a JSON API such as GitHub's REST API v3 or the APIs of GitLab and Gitea, which
+// are modelled after it. The APIs differ in their authentication, rate limit headers and pagination.
+type jsonAPI struct {
+	// name prefixes the error messages, e.g. `github`.
+	name       string
+	baseURL    string
+	httpClient *http.Client
+	// header is sent with every request, e.g. `Accept` or `Authorization`.
+	header http.Header
+	// rateLimit reads the rate limit headers of a response. Every request costs a single point, APIs without
+	// rate limits leave it nil.
+	rateLimit func(h http.Header) rateLimit
+	// pageSize is the query parameter limiting the number of items per page.
+	pageSize string
+	// nextPage returns the cursor of the page following `page`, an empty string if it is the last one. The page
+	// contained `n` of at most `first` items.
+	nextPage func(h http.Header, page, n, first int) string
+}
+
+// get decodes the JSON response of `path` into `v`. It returns false if the resource does not exist.
+func (api *jsonAPI) get(ctx context.Context, path string, v interface{}) (bool, http.Header, rateLimit, error) {
+	limit := rateLimit{Cost: 1}
+	req, err := http.NewRequest(http.MethodGet, api.baseURL+path, nil)
+	if err != nil {
+		return false, nil, limit, err
+	}
+	for name, values := range api.header {
+		req.Header[name] = values
+	}
+
+	resp, err := api.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, nil, limit, transportError(err)
+	}
+	defer resp.Body.Close()
+
+	if api.rateLimit != nil {
+		limit = api.rateLimit(resp.Header)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, resp.Header, limit, nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && limit.Limit > 0 && limit.Remaining == 0 && resp.Header.Get("Retry-After") == "" {
+		// the rate limit headers of the API tell when the limit is reset.
+		return false, resp.Header, limit, GitHubError{rateLimitedError{ResetAt: limit.ResetAt}, TypeRateLimited}
+	}
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		err := fmt.Errorf("%s: non-200 OK status code: %s", api.name, resp.Status)
+		return false, resp.Header, limit, statusError(err, resp.StatusCode, resp.Header, body.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, resp.Header, limit, GitHubError{err, TypeUpstream}
+	}
+	return true, resp.Header, limit, nil
+}
+
+// page decodes the page `cursor` of the list `path` into the slice pointed to by `v`, the first page if `cursor` is
+// empty. The cursor of the following page is returned, an empty string if there is none.
+func (api *jsonAPI) page(ctx context.Context, path string, first int, cursor string, v interface{}) (bool, string, rateLimit, error) {
+	pageNumber := 1
+	if cursor != "" {
+		pageNumber, _ = strconv.Atoi(cursor)
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	found, header, limit, err := api.get(ctx, fmt.Sprintf("%s%s%s=%d&page=%d", path, separator, api.pageSize, first, pageNumber), v)
+	if err != nil || !found {
+		return found, "", limit, err
+	}
+	return true, api.nextPage(header, pageNumber, reflect.ValueOf(v).Elem().Len(), first), limit, nil
+}
+
+// nextPageByCount assumes there is a following page if the page is full.
+func nextPageByCount(h http.Header, page, n, first int) string {
+	if n == first {
+		return strconv.Itoa(page + 1)
+	}
+	return ""
+}
+
+// nextPageByLink uses the `Link` header to decide whether there is a following page. Instances may return less
+// items per page than requested.
+func nextPageByLink(h http.Header, page, n, first int) string {
+	if strings.Contains(h.Get("Link"), `rel="next"`) {
+		return strconv.Itoa(page + 1)
+	}
+	return ""
+}
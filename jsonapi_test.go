@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestJsonAPI_Page(t *testing.T) {
+	var query string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Link", `<https://example.com/items?page=3>; rel="next"`)
+		w.Write([]byte(`[{}, {}]`))
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	api := jsonAPI{
+		name:       "test",
+		baseURL:    httpServer.URL,
+		httpClient: http.DefaultClient,
+		header:     http.Header{"Authorization": {"token secret"}},
+		pageSize:   "limit",
+		nextPage:   nextPageByLink,
+	}
+	var items []struct{}
+	found, next, limit, err := api.page(context.Background(), "/items?sort=desc", 10, "2", &items)
+	if !found || err != nil {
+		t.Fatalf("unexpected result: %v, %v", found, err)
+	}
+	if query != "sort=desc&limit=10&page=2" {
+		t.Errorf("unexpected query '%s'", query)
+	}
+	if next != "3" || len(items) != 2 || limit.Cost != 1 {
+		t.Errorf("unexpected page: next '%s', %d items, cost %d", next, len(items), limit.Cost)
+	}
+
+	// the following page is only assumed by count if the page is full.
+	if next := nextPageByCount(nil, 2, 2, 10); next != "" {
+		t.Errorf("expected last page, got '%s'", next)
+	}
+	if next := nextPageByCount(nil, 2, 10, 10); next != "3" {
+		t.Errorf("expected page 3, got '%s'", next)
+	}
+}
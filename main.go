@@ -4,9 +4,13 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +19,7 @@ import (
 
 const (
 	githubGraphqlEndpoint = "https://api.github.com/graphql"
+	gitlabAPIEndpoint     = "https://gitlab.com/api/v4"
 )
 
 var (
@@ -24,22 +29,151 @@ var (
 
 type gitreleasesEnv struct {
 	addr            string
-	token           string
+	tokens          []string
 	metricsUsername string
 	metricsPassword string
+	searchDepth     int
+	costBudget      int
+	apiMode         APIMode
+	app             *githubAppEnv
+	gitlabURL       string
+	gitlabToken     string
+	// giteaHosts maps the allowed Gitea or Forgejo hosts to their optional access token.
+	giteaHosts map[string]string
+	// enterpriseHosts maps the allowed GitHub Enterprise Server hosts to their access token.
+	enterpriseHosts map[string]string
+	// proxyAccess grants access to proxied downloads of the covered repositories.
+	proxyAccess proxyAccess
+	// blobCacheDir enables the on-disk cache of proxied downloads, which stores at most blobCacheSize bytes.
+	blobCacheDir  string
+	blobCacheSize int64
+	// cacheTTL is the time lookups are cached, stale results are served for another cacheStaleTTL while they are
+	// refreshed. Lookups of missing releases and failed lookups are cached for cacheNotFoundTTL respectively
+	// cacheErrorTTL.
+	cacheTTL         time.Duration
+	cacheStaleTTL    time.Duration
+	cacheNotFoundTTL time.Duration
+	cacheErrorTTL    time.Duration
+	// cacheMaxEntries and cacheMaxSize limit the number of cached lookups and their approximate size in bytes.
+	cacheMaxEntries int
+	cacheMaxSize    int64
+	// cacheFile persists the lookup cache in a file instead of the memory, the cache is kept across restarts.
+	cacheFile string
+	// redisURL enables sharing the lookup cache between replicas using Redis, keys are prefixed by redisKeyPrefix.
+	redisURL       string
+	redisKeyPrefix string
+}
+
+// githubAppEnv configures authentication as GitHub App instead of using personal access tokens.
+type githubAppEnv struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+}
+
+func getGitHubAppEnv() *githubAppEnv {
+	appID := os.Getenv("GITHUB_APP_ID")
+	if appID == "" {
+		return nil
+	}
+	installationID := os.Getenv("GITHUB_APP_INSTALLATION_ID")
+	keyFile := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE")
+
+	if installationID == "" {
+		panic("GITHUB_APP_INSTALLATION_ID is required")
+	}
+	if keyFile == "" {
+		panic("GITHUB_APP_PRIVATE_KEY_FILE is required")
+	}
+
+	env := githubAppEnv{}
+	var err error
+	if env.appID, err = strconv.ParseInt(appID, 10, 64); err != nil {
+		panic("GITHUB_APP_ID must be an integer")
+	}
+	if env.installationID, err = strconv.ParseInt(installationID, 10, 64); err != nil {
+		panic("GITHUB_APP_INSTALLATION_ID must be an integer")
+	}
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		panic("GITHUB_APP_PRIVATE_KEY_FILE cannot be read: " + err.Error())
+	}
+	if env.key, err = ParsePrivateKey(content); err != nil {
+		panic("GITHUB_APP_PRIVATE_KEY_FILE is invalid: " + err.Error())
+	}
+	return &env
+}
+
+// splitTokens splits a comma or newline separated list of tokens.
+func splitTokens(s string) []string {
+	var tokens []string
+	for _, token := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// hostTokens parses a comma separated list of hosts (or other keys), each optionally followed by `=token`,
+// e.g. `codeberg.org,git.example.com=secret`.
+func hostTokens(s string) map[string]string {
+	hosts := make(map[string]string)
+	for _, entry := range splitTokens(s) {
+		host, token := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			host, token = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		hosts[host] = token
+	}
+	return hosts
+}
+
+// intEnv reads an optional positive integer from the environment variable `name`.
+func intEnv(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		panic(name + " must be a positive integer")
+	}
+	return i
+}
+
+// durationEnv reads an optional duration such as `0`, `90s` or `1h` from the environment variable `name`.
+func durationEnv(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		panic(name + " must be a non-negative duration")
+	}
+	return d
 }
 
 func getEnv() gitreleasesEnv {
 	addr := os.Getenv("LISTEN_ADDR")
-	token := os.Getenv("GITHUB_TOKEN")
+	tokens := splitTokens(os.Getenv("GITHUB_TOKEN"))
+	if file := os.Getenv("GITHUB_TOKEN_FILE"); file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			panic("GITHUB_TOKEN_FILE cannot be read: " + err.Error())
+		}
+		tokens = append(tokens, splitTokens(string(content))...)
+	}
 	metricsUsername := os.Getenv("METRICS_USERNAME")
 	metricsPassword := os.Getenv("METRICS_PASSWORD")
+	app := getGitHubAppEnv()
 
 	if addr == "" {
 		panic("LISTEN_ADDR is required")
 	}
-	if token == "" {
-		panic("GITHUB_TOKEN is required")
+	if len(tokens) == 0 && app == nil {
+		panic("GITHUB_TOKEN, GITHUB_TOKEN_FILE or GITHUB_APP_ID is required")
 	}
 	if metricsUsername == "" {
 		panic("METRICS_USERNAME is required")
@@ -47,7 +181,59 @@ func getEnv() gitreleasesEnv {
 	if metricsPassword == "" {
 		panic("METRICS_PASSWORD is required")
 	}
-	return gitreleasesEnv{addr: addr, token: token, metricsUsername: metricsUsername, metricsPassword: metricsPassword}
+	apiMode := ModeAuto
+	if value := os.Getenv("GITHUB_API"); value != "" {
+		var err error
+		if apiMode, err = ParseAPIMode(value); err != nil {
+			panic("GITHUB_API must be one of auto, graphql or rest")
+		}
+	}
+	enterpriseHosts := hostTokens(os.Getenv("GITHUB_ENTERPRISE_HOSTS"))
+	for host, token := range enterpriseHosts {
+		if token == "" {
+			panic("GITHUB_ENTERPRISE_HOSTS requires a token for " + host)
+		}
+	}
+	access := proxyAccess(hostTokens(os.Getenv("PROXY_ACCESS")))
+	for pattern, token := range access {
+		if token == "" {
+			panic("PROXY_ACCESS requires a token for " + pattern)
+		}
+	}
+	gitlabURL := os.Getenv("GITLAB_URL")
+	if gitlabURL == "" {
+		gitlabURL = gitlabAPIEndpoint
+	}
+	redisKeyPrefix, ok := os.LookupEnv("REDIS_KEY_PREFIX")
+	if !ok {
+		redisKeyPrefix = "gitreleases:"
+	}
+	return gitreleasesEnv{
+		addr:             addr,
+		tokens:           tokens,
+		metricsUsername:  metricsUsername,
+		metricsPassword:  metricsPassword,
+		searchDepth:      intEnv("GITHUB_SEARCH_DEPTH", defaultSearchDepth),
+		costBudget:       intEnv("GITHUB_SEARCH_COST_BUDGET", defaultCostBudget),
+		apiMode:          apiMode,
+		app:              app,
+		gitlabURL:        gitlabURL,
+		gitlabToken:      os.Getenv("GITLAB_TOKEN"),
+		giteaHosts:       hostTokens(os.Getenv("GITEA_HOSTS")),
+		enterpriseHosts:  enterpriseHosts,
+		proxyAccess:      access,
+		blobCacheDir:     os.Getenv("BLOB_CACHE_DIR"),
+		blobCacheSize:    int64(intEnv("BLOB_CACHE_SIZE_MB", 1024)) << 20,
+		cacheTTL:         durationEnv("CACHE_TTL", defaultCachePolicy.ttl),
+		cacheStaleTTL:    durationEnv("CACHE_STALE_TTL", time.Hour),
+		cacheNotFoundTTL: durationEnv("CACHE_NOT_FOUND_TTL", defaultCachePolicy.notFoundTTL),
+		cacheErrorTTL:    durationEnv("CACHE_ERROR_TTL", defaultCachePolicy.errorTTL),
+		cacheMaxEntries:  intEnv("CACHE_MAX_ENTRIES", 100000),
+		cacheMaxSize:     int64(intEnv("CACHE_MAX_SIZE_MB", 64)) << 20,
+		cacheFile:        os.Getenv("CACHE_FILE"),
+		redisURL:         os.Getenv("REDIS_URL"),
+		redisKeyPrefix:   redisKeyPrefix,
+	}
 }
 
 func main() {
@@ -60,13 +246,75 @@ func main() {
 
 	env := getEnv()
 
-	cacheTTL := 5 * time.Minute
 	tickerInterval := 10 * time.Minute
-	cache := NewCache(1000, int(cacheTTL.Seconds()), tickerInterval)
+	var localCache Cacher
+	if env.cacheFile != "" {
+		diskCache, err := NewDiskCache(env.cacheFile, env.cacheStaleTTL, tickerInterval, logger.New("module", "gitreleases/diskcache"))
+		if err != nil {
+			panic("CACHE_FILE cannot be used: " + err.Error())
+		}
+		defer diskCache.Close()
+		localCache = diskCache
+	} else {
+		memoryCache := NewCache(env.cacheMaxEntries, env.cacheMaxSize, env.cacheStaleTTL, tickerInterval)
+		defer memoryCache.Close()
+		localCache = memoryCache
+	}
+	cache := localCache
+	if env.redisURL != "" {
+		redisClient, err := NewRedisClient(env.redisURL)
+		if err != nil {
+			panic("REDIS_URL is invalid: " + err.Error())
+		}
+		redisCache := NewRedisCache(redisClient, env.redisKeyPrefix, env.cacheStaleTTL, localCache, logger.New("module", "gitreleases/redis"))
+		defer redisCache.Close()
+		cache = redisCache
+	}
 
-	httpClient := NewOauthClient(context.Background(), env.token)
+	httpClient := &http.Client{Transport: NewTokenPool(env.tokens, http.DefaultTransport)}
+	if env.app != nil {
+		logger.Info("authenticating as GitHub App", "appID", env.app.appID, "installationID", env.app.installationID)
+		httpClient = NewGitHubAppClient(context.Background(), restURL(githubGraphqlEndpoint), env.app.appID, env.app.installationID, env.app.key, http.DefaultClient)
+	}
 	client := NewGitHubClient(githubGraphqlEndpoint, httpClient, cache, logger.New("module", "gitreleases/github"))
-	apiServer := NewAPIServer(env.addr, env.metricsUsername, env.metricsPassword, version, client, logger.New("module", "gitreleases/api"))
+	client.SetSearchLimits(env.searchDepth, env.costBudget)
+	client.SetAPIMode(env.apiMode)
+	client.SetCacheTTLs(env.cacheTTL, env.cacheNotFoundTTL, env.cacheErrorTTL)
+
+	gitlabHTTPClient := http.DefaultClient
+	if env.gitlabToken != "" {
+		gitlabHTTPClient = NewOauthClient(context.Background(), env.gitlabToken)
+	}
+	gitlab := NewGitLabClient(env.gitlabURL, gitlabHTTPClient, cache, logger.New("module", "gitreleases/gitlab"))
+	gitlab.SetSearchLimits(env.searchDepth, env.costBudget)
+	gitlab.SetCacheTTLs(env.cacheTTL, env.cacheNotFoundTTL, env.cacheErrorTTL)
+
+	gitea := make(map[string]*GiteaClient, len(env.giteaHosts))
+	for host, token := range env.giteaHosts {
+		gitea[host] = NewGiteaClient(host, "https://"+host+"/api/v1", token, http.DefaultClient, cache, logger.New("module", "gitreleases/gitea", "host", host))
+		gitea[host].SetSearchLimits(env.searchDepth, env.costBudget)
+		gitea[host].SetCacheTTLs(env.cacheTTL, env.cacheNotFoundTTL, env.cacheErrorTTL)
+	}
+
+	// every GitHub Enterprise Server has its own token pool and therefore tracks its rate limits separately.
+	enterprise := make(map[string]*GithubClient, len(env.enterpriseHosts))
+	for host, token := range env.enterpriseHosts {
+		enterpriseHTTPClient := &http.Client{Transport: NewTokenPool([]string{token}, http.DefaultTransport)}
+		enterprise[host] = NewGitHubEnterpriseClient(host, "https://"+host+"/api/graphql", enterpriseHTTPClient, cache, logger.New("module", "gitreleases/github", "host", host))
+		enterprise[host].SetSearchLimits(env.searchDepth, env.costBudget)
+		enterprise[host].SetAPIMode(env.apiMode)
+		enterprise[host].SetCacheTTLs(env.cacheTTL, env.cacheNotFoundTTL, env.cacheErrorTTL)
+	}
+
+	apiServer := NewAPIServer(env.addr, env.metricsUsername, env.metricsPassword, version, client, gitlab, gitea, enterprise, env.proxyAccess, logger.New("module", "gitreleases/api"))
+
+	if env.blobCacheDir != "" {
+		blobs, err := NewBlobCache(env.blobCacheDir, env.blobCacheSize)
+		if err != nil {
+			panic("BLOB_CACHE_DIR cannot be used: " + err.Error())
+		}
+		apiServer.SetBlobCache(blobs)
+	}
 
 	// Catch SIGINT and SIGTERM.
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -43,10 +44,108 @@ var (
 		},
 		[]string{"code", "method"},
 	)
+
+	retriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_retries_total",
+			Help: "A counter for retried requests to the upstream APIs.",
+		},
+		[]string{"api", "reason"},
+	)
+
+	// The cache metrics are partitioned by the cache implementation, the hits include stale entries.
+	cacheHitsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "A counter for lookups answered from the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	cacheMissesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "A counter for lookups not found in the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	// cacheEvictionsCounter is partitioned by the reason an entry has been removed, either "expired" or "capacity".
+	cacheEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "A counter for entries evicted from the cache, partitioned by reason (expired, capacity).",
+		},
+		[]string{"cache", "reason"},
+	)
+
+	cacheEntriesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_entries",
+			Help: "A gauge of entries currently stored in the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	cacheBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_size_bytes",
+			Help: "A gauge of the approximate size of the entries currently stored in the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	// circuitGauge reports whether the circuit breakers of the upstream APIs are open.
+	circuitGauge = &circuitCollector{
+		desc: prometheus.NewDesc(
+			"upstream_circuit_open",
+			"Whether requests to an upstream API are stopped because its rate limit is exhausted.",
+			[]string{"api"}, nil,
+		),
+	}
 )
 
 func init() {
-	prometheus.MustRegister(inFlightGauge, counter, duration, responseSize)
+	prometheus.MustRegister(inFlightGauge, counter, duration, responseSize, retriesCounter, circuitGauge,
+		cacheHitsCounter, cacheMissesCounter, cacheEvictionsCounter, cacheEntriesGauge, cacheBytesGauge)
+}
+
+// circuitCollector is a `prometheus.Collector` reading the state of the circuit breakers on every scrape, the
+// circuits close by themselves once the rate limit is reset.
+type circuitCollector struct {
+	desc *prometheus.Desc
+
+	l      sync.RWMutex
+	source func() []circuitState
+}
+
+// setSource configures the function returning the circuit states.
+func (cc *circuitCollector) setSource(source func() []circuitState) {
+	cc.l.Lock()
+	cc.source = source
+	cc.l.Unlock()
+}
+
+// Describe implements `prometheus.Collector`.
+func (cc *circuitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.desc
+}
+
+// Collect implements `prometheus.Collector`.
+func (cc *circuitCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.l.RLock()
+	source := cc.source
+	cc.l.RUnlock()
+	if source == nil {
+		return
+	}
+	for _, state := range source() {
+		value := 0.0
+		if state.Open {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(cc.desc, prometheus.GaugeValue, value, state.API)
+	}
 }
 
 func addRequestMetrics(name string, h http.Handler) http.Handler {
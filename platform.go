@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// platform describes the operating system and architecture of a client. Unknown values are empty.
+type platform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+func (p platform) String() string {
+	return fmt.Sprintf("%s/%s", p.OS, p.Arch)
+}
+
+var osAliases = map[string]string{
+	"linux":   "linux",
+	"darwin":  "darwin",
+	"macos":   "darwin",
+	"mac":     "darwin",
+	"osx":     "darwin",
+	"windows": "windows",
+	"win":     "windows",
+	"win32":   "windows",
+	"win64":   "windows",
+	"freebsd": "freebsd",
+}
+
+var archAliases = map[string]string{
+	"amd64":   "amd64",
+	"x8664":   "amd64",
+	"x64":     "amd64",
+	"386":     "386",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+	"arm":     "arm",
+	"armv6":   "arm",
+	"armv7":   "arm",
+	"armhf":   "arm",
+}
+
+// auxiliarySuffixes are files which accompany the actual downloads and are never selected automatically.
+var auxiliarySuffixes = []string{".asc", ".sig", ".pem", ".md5", ".sha1", ".sha256", ".sha512", ".txt", ".sbom", ".spdx", ".json"}
+
+// platformTokens splits a file name or User-Agent into lower case words.
+// `x86_64` is rewritten beforehand as it would be split otherwise.
+func platformTokens(s string) []string {
+	s = strings.NewReplacer("x86_64", "x8664", "x86-64", "x8664").Replace(strings.ToLower(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	})
+}
+
+// detectPlatform returns the first operating system and architecture found in `s`.
+func detectPlatform(s string) platform {
+	var p platform
+	for _, token := range platformTokens(s) {
+		if os, ok := osAliases[token]; ok && p.OS == "" {
+			p.OS = os
+		}
+		if arch, ok := archAliases[token]; ok && p.Arch == "" {
+			p.Arch = arch
+		}
+	}
+	return p
+}
+
+// requestPlatform determines the platform of the caller.
+//
+// The `os` and `arch` query parameters take precedence over the browser client hints
+// (`Sec-CH-UA-Platform`, `Sec-CH-UA-Arch`, `Sec-CH-UA-Bitness`) which in turn take precedence over the User-Agent.
+// curl does not reveal anything about the platform, wget and PowerShell at least reveal the operating system.
+func requestPlatform(r *http.Request) platform {
+	query := r.URL.Query()
+	p := detectPlatform(query.Get("os") + " " + query.Get("arch"))
+
+	hintOS := strings.Trim(r.Header.Get("Sec-CH-UA-Platform"), `"`)
+	hintArch := strings.Trim(r.Header.Get("Sec-CH-UA-Arch"), `"`)
+	if hintArch != "" && strings.Trim(r.Header.Get("Sec-CH-UA-Bitness"), `"`) == "64" {
+		hintArch += "64"
+	}
+	hints := detectPlatform(hintOS + " " + hintArch)
+	ua := detectPlatform(r.UserAgent())
+
+	for _, fallback := range []platform{hints, ua} {
+		if p.OS == "" {
+			p.OS = fallback.OS
+		}
+		if p.Arch == "" {
+			p.Arch = fallback.Arch
+		}
+	}
+	return p
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRank prefers the usual archive format of an operating system and puts installer packages last.
+func formatRank(os, name string) int {
+	name = strings.ToLower(name)
+	switch {
+	case hasAnySuffix(name, ".deb", ".rpm", ".apk", ".msi", ".pkg", ".dmg"):
+		return 2
+	case os == "windows" && !hasAnySuffix(name, ".zip", ".exe"):
+		return 1
+	case os != "windows" && hasAnySuffix(name, ".zip", ".exe"):
+		return 1
+	}
+	return 0
+}
+
+// selectPlatformAsset picks the single asset built for `p`. Assets which don't mention an architecture are
+// considered to be compatible with every architecture.
+//
+// If no single asset can be determined, an `ambiguousAssetError` with the possible choices is returned.
+func selectPlatformAsset(p platform, assets releaseAssetNodes) (releaseAsset, error) {
+	var downloads, candidates releaseAssetNodes
+	for _, asset := range assets {
+		if hasAnySuffix(strings.ToLower(asset.Name), auxiliarySuffixes...) {
+			continue
+		}
+		downloads = append(downloads, asset)
+
+		ap := detectPlatform(asset.Name)
+		if p.OS == "" || ap.OS != p.OS {
+			continue
+		}
+		if p.Arch != "" && ap.Arch != "" && ap.Arch != p.Arch {
+			continue
+		}
+		candidates = append(candidates, asset)
+	}
+
+	if len(downloads) == 0 {
+		return releaseAsset{}, errAssetNotFound
+	}
+	if len(candidates) == 0 {
+		return releaseAsset{}, ambiguousAssetError{Platform: p, Choices: downloads}
+	}
+
+	best := 0
+	var choices releaseAssetNodes
+	for i, asset := range candidates {
+		rank := formatRank(p.OS, asset.Name)
+		if i == 0 || rank < best {
+			best = rank
+			choices = releaseAssetNodes{asset}
+		} else if rank == best {
+			choices = append(choices, asset)
+		}
+	}
+	if len(choices) > 1 {
+		return releaseAsset{}, ambiguousAssetError{Platform: p, Choices: choices}
+	}
+	return choices[0], nil
+}
+
+// ambiguousAssetError lists the assets a client has to choose from, if the platform could not be determined.
+type ambiguousAssetError struct {
+	Platform platform
+	Choices  releaseAssetNodes
+}
+
+func (e ambiguousAssetError) Error() string {
+	return fmt.Sprintf("github: no single asset for platform %s, %d choices", e.Platform, len(e.Choices))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var platformAssets = releaseAssetNodes{
+	{Name: "checksums.txt"},
+	{Name: "tool_1.4.2_darwin_amd64.tar.gz"},
+	{Name: "tool_1.4.2_darwin_arm64.tar.gz"},
+	{Name: "tool_1.4.2_linux_amd64.deb"},
+	{Name: "tool_1.4.2_linux_amd64.tar.gz"},
+	{Name: "tool_1.4.2_linux_arm64.tar.gz"},
+	{Name: "tool_1.4.2_windows_x86_64.zip"},
+}
+
+var selectPlatformAssetCases = map[string]struct {
+	Query     string
+	UserAgent string
+	Expected  string
+	Choices   int
+}{
+	"query parameters": {
+		Query:    "?os=linux&arch=aarch64",
+		Expected: "tool_1.4.2_linux_arm64.tar.gz",
+	},
+	"archive preferred over package": {
+		Query:    "?os=linux&arch=amd64",
+		Expected: "tool_1.4.2_linux_amd64.tar.gz",
+	},
+	"browser": {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/72.0.3626.121 Safari/537.36",
+		Expected:  "tool_1.4.2_windows_x86_64.zip",
+	},
+	"powershell": {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Microsoft Windows 10.0.17763; en-US) PowerShell/6.1.3",
+		Expected:  "tool_1.4.2_windows_x86_64.zip",
+	},
+	"wget without architecture": {
+		UserAgent: "Wget/1.20.1 (linux-gnu)",
+		Choices:   2,
+	},
+	"curl": {
+		UserAgent: "curl/7.64.0",
+		Choices:   6,
+	},
+	"query overrides user agent": {
+		Query:     "?arch=arm64",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_3)",
+		Expected:  "tool_1.4.2_darwin_arm64.tar.gz",
+	},
+}
+
+func TestSelectPlatformAsset(t *testing.T) {
+	for name, data := range selectPlatformAssetCases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/gh/testing/testing/latest/auto"+data.Query, nil)
+			r.Header.Set("User-Agent", data.UserAgent)
+
+			asset, err := selectPlatformAsset(requestPlatform(r), platformAssets)
+			if asset.Name != data.Expected {
+				t.Errorf("asset does not match. Expected: '%s', got '%s'", data.Expected, asset.Name)
+			}
+			if data.Choices == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			ambiguous, ok := err.(ambiguousAssetError)
+			if !ok {
+				t.Fatalf("expected ambiguousAssetError, got '%v'", err)
+			}
+			if len(ambiguous.Choices) != data.Choices {
+				t.Errorf("choices do not match. Expected: %d, got %d", data.Choices, len(ambiguous.Choices))
+			}
+		})
+	}
+}
+
+func TestApiServer_DownloadPlatformRelease_Choices(t *testing.T) {
+	cache := NoopCache{}
+	gh := NewGitHubClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	gh.provider = manifestProvider{platformAssets}
+	gl := NewGitLabClient("http://127.0.0.1:0", http.DefaultClient, &cache, discardLogger())
+	access := proxyAccess{"gh/testing/*": "caller"}
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, access, discardLogger())
+
+	for name, data := range map[string]struct {
+		URL     string
+		Token   string
+		Choices int
+	}{
+		"granted":       {URL: "/gh/testing/testing/latest/auto", Token: "caller", Choices: 6},
+		"missing token": {URL: "/gh/testing/testing/latest/auto"},
+		"wrong token":   {URL: "/gh/testing/testing/latest/auto", Token: "wrong"},
+		"not covered":   {URL: "/gh/other/testing/latest/auto", Token: "caller"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, data.URL, nil)
+			r.Header.Set("User-Agent", "curl/7.64.0")
+			if data.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+data.Token)
+			}
+			w := httptest.NewRecorder()
+			as.server.Handler.ServeHTTP(w, r)
+			if w.Code != http.StatusMultipleChoices {
+				t.Fatalf("expected status 300, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var out struct {
+				Choices []choice `json:"choices"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Choices) != data.Choices {
+				t.Errorf("choices do not match. Expected: %d, got %d", data.Choices, len(out.Choices))
+			}
+		})
+	}
+}
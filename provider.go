@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReleaseProvider resolves the releases of a hosting service such as GitHub or GitLab.
+type ReleaseProvider interface {
+	// ResolveRelease returns the release specified by `tag` (see `releaseSearch.resolve`) together with its assets
+	// matching `pattern`, the pattern `*` lists all assets of the release.
+	//
+	// Errors are returned as `GitHubError`: `TypeNotFound` if the repository, release or asset does not exist and
+	// `TypeUpstream` or `TypeRateLimited` if the service failed.
+	ResolveRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error)
+}
+
+// releaseResolver implements the lookups served by the API on top of a `ReleaseProvider` and caches their results.
+type releaseResolver struct {
+	provider ReleaseProvider
+	cache    Cacher
+	// namespace prefixes the cache keys, it distinguishes the providers sharing a cache.
+	namespace string
+	// flights coalesces concurrent lookups of the same release.
+	flights flightGroup
+	policy  cachePolicy
+	// manifestClient downloads checksum manifests.
+	manifestClient *http.Client
+
+	l sync.Mutex
+	// refreshes are the keys of the stale cache entries being refreshed.
+	refreshes map[string]bool
+}
+
+func newReleaseResolver(namespace string, provider ReleaseProvider, cache Cacher) *releaseResolver {
+	return &releaseResolver{provider: provider, cache: cache, namespace: namespace, policy: defaultCachePolicy, manifestClient: manifestClient, refreshes: make(map[string]bool)}
+}
+
+// cachePolicy decides how long the results of lookups are cached depending on their outcome.
+type cachePolicy struct {
+	// ttl applies to successful lookups.
+	ttl time.Duration
+	// notFoundTTL applies to lookups of repositories, releases or assets which do not exist, as well as to other
+	// negative answers such as ambiguous platform assets.
+	notFoundTTL time.Duration
+	// errorTTL applies to failures of the upstream API such as server errors, timeouts or rejected credentials.
+	errorTTL time.Duration
+}
+
+var defaultCachePolicy = cachePolicy{ttl: 5 * time.Minute, notFoundTTL: time.Minute}
+
+// ttlOf returns how long the result of a lookup failing with `err`, nil on success, is cached. Rate limit errors
+// are never cached, the circuit breaker answers them until the limit is reset. Neither are lookups given up by
+// their caller.
+func (cp cachePolicy) ttlOf(err error) time.Duration {
+	if err == nil {
+		return cp.ttl
+	}
+	if contextError(err) {
+		return 0
+	}
+	switch t := err.(type) {
+	case ambiguousAssetError:
+		return cp.notFoundTTL
+	case GitHubError:
+		switch t.Type {
+		case TypeNotFound:
+			return cp.notFoundTTL
+		case TypeRateLimited:
+			return 0
+		}
+	}
+	return cp.errorTTL
+}
+
+// contextError returns true if `err` has been caused by a cancelled context or an exceeded deadline.
+func contextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// transient returns true if `err` is a temporary failure of the upstream API or the lookup has not finished in time.
+func transient(err error) bool {
+	if contextError(err) {
+		return true
+	}
+	t, ok := err.(GitHubError)
+	return ok && t.Type != TypeNotFound
+}
+
+// cacheKey builds the cache key of a lookup out of its `parts`.
+func (rr *releaseResolver) cacheKey(parts ...string) string {
+	key := rr.namespace
+	for _, part := range parts {
+		key += "/" + part
+	}
+	return key
+}
+
+// store caches the result of the lookup `cacheKey` according to the cache policy.
+func (rr *releaseResolver) store(cacheKey, value string, err error) {
+	rr.cache.Put(cacheKey, value, err, rr.policy.ttlOf(err))
+}
+
+// cached returns the cached result of the lookup `cacheKey`. Uncached lookups are resolved using `lookup` and
+// cached. Stale entries are returned as well, they are refreshed in the background.
+func (rr *releaseResolver) cached(ctx context.Context, cacheKey string, lookup func(ctx context.Context) (string, error)) (string, error) {
+	cached, stale, err := rr.cache.Get(cacheKey)
+	if cached != "" || err != nil {
+		if stale {
+			rr.refresh(cacheKey, lookup)
+		}
+		return cached, err
+	}
+
+	value, err := lookup(ctx)
+	rr.store(cacheKey, value, err)
+	return value, err
+}
+
+// refresh resolves the stale lookup `cacheKey` again in the background, unless it is already being refreshed.
+// The stale entry is kept if the lookup fails temporarily.
+func (rr *releaseResolver) refresh(cacheKey string, lookup func(ctx context.Context) (string, error)) {
+	rr.l.Lock()
+	defer rr.l.Unlock()
+	if rr.refreshes[cacheKey] {
+		return
+	}
+	rr.refreshes[cacheKey] = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		if value, err := lookup(ctx); !transient(err) {
+			rr.store(cacheKey, value, err)
+		}
+
+		rr.l.Lock()
+		delete(rr.refreshes, cacheKey)
+		rr.l.Unlock()
+	}()
+}
+
+// SetCacheTTLs configures how long successful lookups, lookups of missing repositories, releases or assets and
+// failed lookups are cached. A TTL of zero disables caching.
+func (rr *releaseResolver) SetCacheTTLs(ttl, notFoundTTL, errorTTL time.Duration) {
+	rr.policy = cachePolicy{ttl: ttl, notFoundTTL: notFoundTTL, errorTTL: errorTTL}
+}
+
+// fetchRelease returns the resolved release with its assets matching `pattern`, there is at least one such asset.
+//
+// Concurrent lookups of the same release share a single request to the provider.
+func (rr *releaseResolver) fetchRelease(ctx context.Context, owner, repo, tag string, pattern assetPattern) (resolvedRelease, error) {
+	release, err := rr.flights.do(ctx, rr.cacheKey(owner, repo, tag, string(pattern)), func(ctx context.Context) (resolvedRelease, error) {
+		return rr.provider.ResolveRelease(ctx, owner, repo, tag, pattern)
+	})
+	if err != nil {
+		return release, err
+	}
+	if len(release.Assets) == 0 {
+		return release, errAssetNotFound
+	}
+	return release, nil
+}
+
+// FetchReleaseURL returns the download URL of the asset `assetName` of the release specified by `tag`.
+//
+// `assetName` may be a pattern, see `assetPattern`.
+func (rr *releaseResolver) FetchReleaseURL(ctx context.Context, owner, repo, tag, assetName string) (string, error) {
+	return rr.cached(ctx, rr.cacheKey(owner, repo, tag, assetName), func(ctx context.Context) (string, error) {
+		return rr.fetchReleaseURL(ctx, owner, repo, tag, assetName)
+	})
+}
+
+func (rr *releaseResolver) fetchReleaseURL(ctx context.Context, owner, repo, tag, assetName string) (string, error) {
+	release, err := rr.fetchRelease(ctx, owner, repo, tag, assetPattern(assetName))
+	if err != nil {
+		return "", err
+	}
+	return release.Assets[0].DownloadUrl, nil
+}
+
+// FetchPlatformReleaseURL returns the download URL of the asset of the release specified by `tag` which
+// has been built for platform `p`.
+//
+// An `ambiguousAssetError` is returned if there is not exactly one such asset.
+func (rr *releaseResolver) FetchPlatformReleaseURL(ctx context.Context, owner, repo, tag string, p platform) (string, error) {
+	return rr.cached(ctx, rr.cacheKey(owner, repo, tag, "auto", p.String()), func(ctx context.Context) (string, error) {
+		release, err := rr.fetchRelease(ctx, owner, repo, tag, assetPattern("*"))
+		if err != nil {
+			return "", err
+		}
+		asset, err := selectPlatformAsset(p, release.Assets)
+		if err != nil {
+			return "", err
+		}
+		return asset.DownloadUrl, nil
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var errProxyNotSupported = NewGitHubError("proxied downloads are not supported by this provider", TypeNotFound)
+
+// proxyHeaders are the request headers forwarded to the download of a proxied asset.
+var proxyHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// AssetDownloader is implemented by providers which can download release assets using the server's credentials,
+// e.g. assets of private repositories.
+type AssetDownloader interface {
+	// AssetURL returns the API URL of `asset` of the release tagged `tag`.
+	AssetURL(ctx context.Context, owner, repo, tag string, asset releaseAsset) (string, error)
+	// DownloadAsset requests the asset at the API URL `url`. The `proxyHeaders` of `header` are forwarded.
+	// The caller has to close the body of the response.
+	DownloadAsset(ctx context.Context, url string, header http.Header) (*http.Response, error)
+}
+
+// proxyAsset is a release asset which is downloaded using the server's credentials.
+type proxyAsset struct {
+	// Key identifies the asset by the resolved `namespace/owner/repo/tag/asset`.
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// URL is the API URL of the asset.
+	URL string `json:"url"`
+}
+
+// FetchProxyAsset returns the asset `assetName` of the release specified by `tag`.
+func (rr *releaseResolver) FetchProxyAsset(ctx context.Context, owner, repo, tag, assetName string) (proxyAsset, error) {
+	var asset proxyAsset
+	downloader, ok := rr.provider.(AssetDownloader)
+	if !ok {
+		return asset, errProxyNotSupported
+	}
+
+	cached, err := rr.cached(ctx, rr.cacheKey(owner, repo, tag, assetName, "proxy"), func(ctx context.Context) (string, error) {
+		release, err := rr.fetchRelease(ctx, owner, repo, tag, assetPattern(assetName))
+		if err != nil {
+			return "", err
+		}
+		found := proxyAsset{
+			Key:  rr.cacheKey(owner, repo, release.TagName, release.Assets[0].Name),
+			Name: release.Assets[0].Name,
+		}
+		if found.URL, err = downloader.AssetURL(ctx, owner, repo, release.TagName, release.Assets[0]); err != nil {
+			return "", err
+		}
+		encoded, err := json.Marshal(&found)
+		return string(encoded), err
+	})
+	if err != nil {
+		return asset, err
+	}
+	err = json.Unmarshal([]byte(cached), &asset)
+	return asset, err
+}
+
+// DownloadAsset requests the asset returned by `FetchProxyAsset`, see `AssetDownloader`.
+func (rr *releaseResolver) DownloadAsset(ctx context.Context, asset proxyAsset, header http.Header) (*http.Response, error) {
+	downloader, ok := rr.provider.(AssetDownloader)
+	if !ok {
+		return nil, errProxyNotSupported
+	}
+	return downloader.DownloadAsset(ctx, asset.URL, header)
+}
+
+// AssetURL implements `AssetDownloader` using the REST API, which identifies assets by their numeric ID.
+func (gh *GithubClient) AssetURL(ctx context.Context, owner, repo, tag string, asset releaseAsset) (string, error) {
+	switch assetPattern(asset.Name) {
+	case sourceTarball:
+		return gh.rest.baseURL + repoPath(owner, repo) + "/tarball/" + url.PathEscape(tag), nil
+	case sourceZipball:
+		return gh.rest.baseURL + repoPath(owner, repo) + "/zipball/" + url.PathEscape(tag), nil
+	}
+
+	if err := gh.restBreaker.check(); err != nil {
+		return "", err
+	}
+	var release restRelease
+	found, _, limit, err := gh.rest.get(ctx, repoPath(owner, repo)+"/releases/tags/"+url.PathEscape(tag), &release)
+	gh.restBreaker.observe(limit, err)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", errReleaseNotFound
+	}
+	for _, a := range release.Assets {
+		if a.Name == asset.Name {
+			return a.URL, nil
+		}
+	}
+	return "", errAssetNotFound
+}
+
+// DownloadAsset implements `AssetDownloader`.
+//
+// GitHub redirects to a signed URL of its storage. The redirect is followed without the server's credentials,
+// as the storage rejects requests carrying more than one authentication mechanism.
+func (gh *GithubClient) DownloadAsset(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	copyHeaders(req.Header, header, proxyHeaders)
+
+	client := *gh.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	if location := resp.Header.Get("Location"); location != "" && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		resp.Body.Close()
+		if req, err = http.NewRequest(http.MethodGet, location, nil); err != nil {
+			return nil, GitHubError{err, TypeUpstream}
+		}
+		copyHeaders(req.Header, header, proxyHeaders)
+		if resp, err = http.DefaultClient.Do(req.WithContext(ctx)); err != nil {
+			return nil, transportError(err)
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errAssetNotFound
+	}
+	resp.Body.Close()
+	return nil, NewGitHubError(fmt.Sprintf("github: downloading asset failed: %s", resp.Status), TypeUpstream)
+}
+
+func copyHeaders(dst, src http.Header, names []string) {
+	for _, name := range names {
+		if value := src.Get(name); value != "" {
+			dst.Set(name, value)
+		}
+	}
+}
+
+// proxyAccess grants access to proxied downloads. It maps patterns of repositories (see `path.Match`) to the token
+// callers have to present. Repositories are identified by the cache namespace of their provider, e.g. `gh/owner/repo`,
+// `ghe/host/owner/repo` or `gl/group/project`, the pattern `gh/owner/*` covers all repositories of `owner`.
+type proxyAccess map[string]string
+
+// requestToken returns the bearer token or the basic auth password of the request.
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return auth[7:]
+	}
+	return ""
+}
+
+// check returns whether any rule covers `repository` and whether `token` is accepted by one of those rules.
+func (pa proxyAccess) check(repository, token string) (covered, granted bool) {
+	for pattern, expected := range pa {
+		if ok, err := path.Match(pattern, repository); err != nil || !ok {
+			continue
+		}
+		covered = true
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return true, true
+		}
+	}
+	return covered, false
+}
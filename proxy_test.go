@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const proxiedContent = "proxied release asset"
+
+// proxyServer emulates GitHub's APIs and its storage, which rejects requests carrying credentials.
+func proxyServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			fmt.Fprint(w, `{"data": {"repository": {"release": {"releaseAssets": {"nodes": [
+				{"name": "testing.zip", "downloadUrl": "https://example.com/testing/testing/releases/download/sometag/testing.zip"}
+			]}}}}}`)
+		case r.URL.Path == "/repos/testing/testing/releases/tags/sometag":
+			fmt.Fprintf(w, `{"tag_name": "sometag", "assets": [
+				{"name": "testing.zip", "url": "%s/repos/testing/testing/releases/assets/1"}
+			]}`, server.URL)
+		case r.URL.Path == "/repos/testing/testing/releases/assets/1":
+			if r.Header.Get("Authorization") != "bearer secret" || r.Header.Get("Accept") != "application/octet-stream" {
+				t.Errorf("unexpected asset request headers: %v", r.Header)
+			}
+			http.Redirect(w, r, server.URL+"/storage/testing.zip", http.StatusFound)
+		case r.URL.Path == "/storage/testing.zip":
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			http.ServeContent(w, r, "testing.zip", time.Time{}, strings.NewReader(proxiedContent))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestApiServer_ProxyRelease(t *testing.T) {
+	httpServer := proxyServer(t)
+	defer httpServer.Close()
+
+	cache := NoopCache{}
+	httpClient := &http.Client{Transport: NewTokenPool([]string{"secret"}, http.DefaultTransport)}
+	gh := NewGitHubClient(httpServer.URL, httpClient, &cache, discardLogger())
+	gl := NewGitLabClient(httpServer.URL, http.DefaultClient, &cache, discardLogger())
+	access := proxyAccess{"gh/testing/*": "caller"}
+	as := NewAPIServer(":0", "metrics", "metrics", "test", gh, gl, nil, nil, access, discardLogger())
+
+	tests := map[string]struct {
+		URL          string
+		Token        string
+		Range        string
+		Status       int
+		Body         string
+		ContentRange string
+	}{
+		"not covered": {
+			URL:    "/gh/other/testing/sometag/testing.zip/download",
+			Token:  "caller",
+			Status: http.StatusForbidden,
+		},
+		"missing token": {
+			URL:    "/gh/testing/testing/sometag/testing.zip/download",
+			Status: http.StatusUnauthorized,
+		},
+		"wrong token": {
+			URL:    "/gh/testing/testing/sometag/testing.zip/download",
+			Token:  "wrong",
+			Status: http.StatusUnauthorized,
+		},
+		"download": {
+			URL:    "/gh/testing/testing/sometag/testing.zip/download",
+			Token:  "caller",
+			Status: http.StatusOK,
+			Body:   proxiedContent,
+		},
+		"range": {
+			URL:          "/gh/testing/testing/sometag/testing.zip/download",
+			Token:        "caller",
+			Range:        "bytes=0-6",
+			Status:       http.StatusPartialContent,
+			Body:         proxiedContent[:7],
+			ContentRange: fmt.Sprintf("bytes 0-6/%d", len(proxiedContent)),
+		},
+		"asset not found": {
+			URL:    "/gh/testing/testing/othertag/testing.zip/download",
+			Token:  "caller",
+			Status: http.StatusNotFound,
+		},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, data.URL, nil)
+			if data.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+data.Token)
+			}
+			if data.Range != "" {
+				r.Header.Set("Range", data.Range)
+			}
+			w := httptest.NewRecorder()
+			as.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != data.Status {
+				t.Fatalf("status does not match. Expected: %d, got %d: %s", data.Status, w.Code, w.Body.String())
+			}
+			if data.Body == "" {
+				return
+			}
+			if !bytes.Equal(w.Body.Bytes(), []byte(data.Body)) {
+				t.Errorf("body does not match. Expected: '%s', got '%s'", data.Body, w.Body.String())
+			}
+			if w.Header().Get("Content-Length") != fmt.Sprint(len(data.Body)) {
+				t.Errorf("unexpected Content-Length '%s'", w.Header().Get("Content-Length"))
+			}
+			if w.Header().Get("Content-Range") != data.ContentRange {
+				t.Errorf("unexpected Content-Range '%s'", w.Header().Get("Content-Range"))
+			}
+			if w.Header().Get("Content-Disposition") != "attachment; filename=testing.zip" {
+				t.Errorf("unexpected Content-Disposition '%s'", w.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
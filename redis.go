@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/inconshreveable/log15"
+)
+
+const (
+	// redisTimeout limits the time spent on a Redis command, lookups fall back to the in-memory cache if Redis is
+	// slow to answer.
+	redisTimeout = 200 * time.Millisecond
+	// redisRetryInterval is the time Redis is not used after it failed, the fallback cache is used meanwhile.
+	redisRetryInterval = 10 * time.Second
+)
+
+// RedisCache is a `Cacher` storing the entries in Redis, which allows replicas to share their lookups. Keys are
+// prefixed to share a Redis database with other applications. In exchange every lookup costs a round trip to Redis,
+// and Redis decides how many entries are kept, e.g. by its `maxmemory` policy.
+//
+// If Redis cannot be reached, entries are stored in and read from the `fallback` cache instead, they are not shared
+// with the other replicas meanwhile. Redis is tried again after `redisRetryInterval`.
+type RedisCache struct {
+	client   *redis.Client
+	prefix   string
+	staleTTL time.Duration
+	fallback Cacher
+	logger   log.Logger
+
+	l         sync.Mutex
+	downUntil time.Time
+}
+
+// NewRedisClient connects to the Redis server at `url`, e.g. `redis://:password@localhost:6379/0`. Commands are not
+// retried and time out after `redisTimeout`.
+func NewRedisClient(url string) (*redis.Client, error) {
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	opt.MaxRetries = 0
+	opt.DialTimeout = redisTimeout
+	opt.ReadTimeout = redisTimeout
+	opt.WriteTimeout = redisTimeout
+	return redis.NewClient(opt), nil
+}
+
+// NewRedisCache creates a cache using `client`. Redis expires the keys by itself, values `staleTTL` after their TTL
+// and errors right after it, the entries need not be swept.
+func NewRedisCache(client *redis.Client, prefix string, staleTTL time.Duration, fallback Cacher, logger log.Logger) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix, staleTTL: staleTTL, fallback: fallback, logger: logger}
+}
+
+// available returns false if Redis failed recently.
+func (rc *RedisCache) available() bool {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	return time.Now().After(rc.downUntil)
+}
+
+// failed records that Redis failed with `err`, the fallback cache is used until `redisRetryInterval` passed.
+func (rc *RedisCache) failed(err error) {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	if time.Now().After(rc.downUntil) {
+		rc.logger.Warn("redis unavailable, using in-memory cache", "err", err, "retryIn", redisRetryInterval)
+	}
+	rc.downUntil = time.Now().Add(redisRetryInterval)
+}
+
+// Put stores the entry in Redis, it expires after `ttl` if it is an error, otherwise after `ttl` and the stale TTL.
+func (rc *RedisCache) Put(k, v string, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if !rc.available() {
+		rc.fallback.Put(k, v, err, ttl)
+		return
+	}
+	encoded, jsonErr := json.Marshal(cachedEntry{Value: v, Error: encodeError(err), StoredAt: time.Now(), TTL: ttl})
+	if jsonErr != nil {
+		rc.logger.Error("cannot encode cache entry", "key", k, "err", jsonErr)
+		return
+	}
+	expiration := ttl
+	if err == nil {
+		expiration += rc.staleTTL
+	}
+	if redisErr := rc.client.Set(rc.prefix+k, encoded, expiration).Err(); redisErr != nil {
+		rc.failed(redisErr)
+		rc.fallback.Put(k, v, err, ttl)
+	}
+}
+
+// Get retrieves the entry from Redis. Entries which cannot be decoded are treated as missing.
+func (rc *RedisCache) Get(k string) (v string, stale bool, err error) {
+	if !rc.available() {
+		return rc.fallback.Get(k)
+	}
+	encoded, redisErr := rc.client.Get(rc.prefix + k).Bytes()
+	if redisErr == redis.Nil {
+		cacheMissesCounter.WithLabelValues("redis").Inc()
+		return
+	}
+	if redisErr != nil {
+		rc.failed(redisErr)
+		return rc.fallback.Get(k)
+	}
+
+	var entry cachedEntry
+	if jsonErr := json.Unmarshal(encoded, &entry); jsonErr != nil {
+		rc.logger.Warn("cannot decode cache entry", "key", k, "err", jsonErr)
+		cacheMissesCounter.WithLabelValues("redis").Inc()
+		return
+	}
+	cacheHitsCounter.WithLabelValues("redis").Inc()
+	return entry.Value, time.Since(entry.StoredAt) > entry.TTL, entry.Error.decode()
+}
+
+// Close closes the connections to Redis.
+func (rc *RedisCache) Close() error {
+	return rc.client.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+)
+
+func tempRedisCache(t *testing.T, fallback Cacher) (*RedisCache, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("cannot start redis: %v", err)
+	}
+	client, err := NewRedisClient("redis://" + server.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return NewRedisCache(client, "test:", time.Hour, fallback, discardLogger()), server
+}
+
+func TestRedisCache(t *testing.T) {
+	cache, server := tempRedisCache(t, &NoopCache{})
+	defer server.Close()
+	defer cache.Close()
+
+	ambiguous := ambiguousAssetError{Platform: platform{OS: "linux", Arch: "amd64"}, Choices: releaseAssetNodes{{Name: "a.tar.gz", DownloadUrl: "https://example.com/a.tar.gz"}}}
+	for k, data := range map[string]struct {
+		Value string
+		Err   error
+	}{
+		"value":           {Value: "https://example.com/tool.zip"},
+		"release missing": {Err: errReleaseNotFound},
+		"asset missing":   {Err: errAssetNotFound},
+		"upstream":        {Err: NewGitHubError("bad gateway", TypeUpstream)},
+		"ambiguous":       {Err: ambiguous},
+	} {
+		cache.Put(k, data.Value, data.Err, time.Minute)
+		v, stale, err := cache.Get(k)
+		if v != data.Value || stale || !reflect.DeepEqual(err, data.Err) {
+			t.Errorf("%s: expected '%s' and err '%v', got '%s' and err '%v' (stale: %v)", k, data.Value, data.Err, v, err, stale)
+		}
+	}
+	if _, _, err := cache.Get("release missing"); err != errReleaseNotFound {
+		t.Errorf("expected errReleaseNotFound to be restored, got %#v", err)
+	}
+
+	// keys are prefixed, values are kept for their stale TTL while errors expire right away.
+	if ttl := server.TTL("test:value"); ttl != time.Minute+time.Hour {
+		t.Errorf("expected value to expire after 1h1m, got %s", ttl)
+	}
+	if ttl := server.TTL("test:upstream"); ttl != time.Minute {
+		t.Errorf("expected error to expire after 1m, got %s", ttl)
+	}
+	server.FastForward(2 * time.Minute)
+	if _, _, err := cache.Get("upstream"); err != nil {
+		t.Errorf("expected expired error, got %v", err)
+	}
+	if v, _, _ := cache.Get("missing"); v != "" {
+		t.Errorf("expected missing entry, got '%s'", v)
+	}
+}
+
+func TestRedisCache_Stale(t *testing.T) {
+	cache, server := tempRedisCache(t, &NoopCache{})
+	defer server.Close()
+	defer cache.Close()
+
+	cache.Put("key", "value", nil, 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if v, stale, _ := cache.Get("key"); v != "value" || !stale {
+		t.Errorf("expected stale value, got '%s' (stale: %v)", v, stale)
+	}
+}
+
+func TestRedisCache_Fallback(t *testing.T) {
+	fallback := NewCache(10, 0, time.Hour, time.Hour)
+	defer fallback.Close()
+	cache, server := tempRedisCache(t, fallback)
+	defer cache.Close()
+	server.Close()
+
+	// the lookup is cached in memory while redis is unreachable.
+	cache.Put("key", "value", nil, time.Minute)
+	if v, _, _ := cache.Get("key"); v != "value" {
+		t.Errorf("expected value from fallback cache, got '%s'", v)
+	}
+	if v, _, _ := fallback.Get("key"); v != "value" {
+		t.Errorf("expected value to be stored in fallback cache, got '%s'", v)
+	}
+}
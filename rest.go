@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// restBackend retrieves releases using the REST API v3. Its rate limit is independent of the GraphQL API's one,
+// which makes it a suitable fallback.
+type restBackend struct {
+	jsonAPI
+	// repoNotFound creates the error returned if the repository does not exist.
+	repoNotFound func(owner, repo string) error
+}
+
+type restRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	TarballURL string `json:"tarball_url"`
+	ZipballURL string `json:"zipball_url"`
+	Assets     []struct {
+		Name               string `json:"name"`
+		URL                string `json:"url"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// node converts the release into the representation used by the GraphQL API.
+func (r restRelease) node(pattern assetPattern) releaseNode {
+	node := releaseNode{
+		TagName:      r.TagName,
+		IsDraft:      r.Draft,
+		IsPrerelease: r.Prerelease,
+		TarballUrl:   r.TarballURL,
+		ZipballUrl:   r.ZipballURL,
+	}
+	for _, asset := range r.Assets {
+		// the REST API has no name filter, emulate the one of the GraphQL API.
+		if pattern.isLiteral() && asset.Name != string(pattern) {
+			continue
+		}
+		node.ReleaseAssets.Nodes = append(node.ReleaseAssets.Nodes, releaseAsset{Name: asset.Name, DownloadUrl: asset.BrowserDownloadURL})
+	}
+	return node
+}
+
+// restURL derives the REST API URL from the GraphQL API URL.
+// Both `https://api.github.com/graphql` and GitHub Enterprise's `https://host/api/graphql` are supported.
+func restURL(graphqlURL string) string {
+	base := strings.TrimSuffix(graphqlURL, "/graphql")
+	if strings.HasSuffix(base, "/api") {
+		return base + "/v3"
+	}
+	return base
+}
+
+func newRestBackend(graphqlURL string, httpClient *http.Client) *restBackend {
+	return &restBackend{jsonAPI{
+		name:       "github",
+		baseURL:    restURL(graphqlURL),
+		httpClient: httpClient,
+		header:     http.Header{"Accept": {"application/vnd.github.v3+json"}},
+		rateLimit:  restRateLimit,
+		pageSize:   "per_page",
+		nextPage:   nextPageByCount,
+	}, githubRepoNotFound}
+}
+
+// githubRepoNotFound returns the same error as the GraphQL API for a repository which does not exist.
+func githubRepoNotFound(owner, repo string) error {
+	return NewGitHubError(fmt.Sprintf("Could not resolve to a Repository with the name '%s/%s'.", owner, repo), TypeNotFound)
+}
+
+// restRateLimit reads the rate limit headers. Every request costs a single point.
+func restRateLimit(h http.Header) rateLimit {
+	limit, _ := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, _ := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	return rateLimit{Limit: limit, Cost: 1, Remaining: remaining, ResetAt: time.Unix(reset, 0)}
+}
+
+func repoPath(owner, repo string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func (rb *restBackend) releaseByTag(ctx context.Context, owner, repo, tag string, pattern assetPattern) (*releaseNode, rateLimit, error) {
+	var release restRelease
+	found, _, limit, err := rb.get(ctx, repoPath(owner, repo)+"/releases/tags/"+url.PathEscape(tag), &release)
+	if err != nil || !found {
+		return nil, limit, err
+	}
+	node := release.node(pattern)
+	return &node, limit, nil
+}
+
+func (rb *restBackend) releases(ctx context.Context, owner, repo string, pattern assetPattern, first int, cursor string) (releasePage, rateLimit, error) {
+	var releases []restRelease
+	found, next, limit, err := rb.page(ctx, repoPath(owner, repo)+"/releases", first, cursor, &releases)
+	if err != nil {
+		return releasePage{}, limit, err
+	}
+	if !found {
+		return releasePage{}, limit, rb.repoNotFound(owner, repo)
+	}
+
+	page := releasePage{Next: next}
+	for _, release := range releases {
+		page.Releases = append(page.Releases, release.node(pattern))
+	}
+
+	if cursor == "" {
+		var latest restRelease
+		found, _, latestLimit, err := rb.get(ctx, repoPath(owner, repo)+"/releases/latest", &latest)
+		latestLimit.Cost += limit.Cost
+		limit = latestLimit
+		if err != nil {
+			return releasePage{}, limit, err
+		}
+		if found {
+			node := latest.node(pattern)
+			page.Latest = &node
+		}
+	}
+	return page, limit, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// retryPolicy retries failed idempotent requests using exponential backoff with full jitter.
+type retryPolicy struct {
+	// attempts is the maximum number of attempts including the first one.
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{attempts: 3, base: 100 * time.Millisecond, max: time.Second}
+
+// backoff returns a random delay before the retry following the failed attempt number `attempt`. The upper bound
+// of the delay doubles with every attempt.
+func (rp retryPolicy) backoff(attempt int) time.Duration {
+	limit := rp.base << uint(attempt-1)
+	if limit > rp.max || limit <= 0 {
+		limit = rp.max
+	}
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
+}
+
+// wait sleeps for `delay`. It returns false without waiting if the deadline of `ctx` would pass in the meantime,
+// or as soon as `ctx` is done.
+func (rp retryPolicy) wait(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
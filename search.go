@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	log "github.com/inconshreveable/log15"
+)
+
+// releaseChannel selects which kind of releases are considered when looking up the latest release.
+type releaseChannel int
+
+const (
+	// channelStable only considers releases which are not marked as prerelease, GitHub's "Latest" release first.
+	channelStable releaseChannel = iota
+	// channelPrerelease only considers prereleases.
+	channelPrerelease
+	// channelAny considers all releases.
+	channelAny
+)
+
+// latestChannels maps the supported `tag` values for the latest release to their channel.
+var latestChannels = map[string]releaseChannel{
+	"latest":            channelStable,
+	"latest-prerelease": channelPrerelease,
+	"latest-any":        channelAny,
+}
+
+func (c releaseChannel) contains(node releaseNode) bool {
+	if node.IsDraft {
+		return false
+	}
+	switch c {
+	case channelStable:
+		return !node.IsPrerelease
+	case channelPrerelease:
+		return node.IsPrerelease
+	}
+	return true
+}
+
+const (
+	// latestPageSize is the number of releases fetched per page when looking for the latest release.
+	// The asset is usually found within the first few releases, therefore the first page is kept small.
+	latestPageSize = 10
+	// rangePageSize is the number of releases fetched per page when resolving a version range.
+	// All releases have to be inspected for ranges.
+	rangePageSize = 100
+
+	// defaultSearchDepth is the default maximum number of releases inspected for a single lookup.
+	defaultSearchDepth = 500
+	// defaultCostBudget is the default maximum number of rate limit points spent for a single lookup.
+	defaultCostBudget = 10
+	// reservedPoints are never spent by paging through releases.
+	reservedPoints = 50
+)
+
+// releaseSearch looks up releases using a `releaseBackend`, limiting how many releases are paged through.
+type releaseSearch struct {
+	depth      int
+	costBudget int
+	logger     log.Logger
+}
+
+// pageReleases pages through the releases, newest first, and calls `fn` for every page until it returns false.
+//
+// Paging stops as well if the search depth is reached, the cost budget is spent or the remaining rate limit points
+// drop below `reservedPoints`. The returned rate limit contains the accumulated cost.
+func (s releaseSearch) pageReleases(ctx context.Context, backend releaseBackend, owner, repo string, pattern assetPattern, pageSize int, fn func(p releasePage) bool) (rateLimit, error) {
+	var currLimit rateLimit
+	cursor := ""
+	inspected := 0
+	for {
+		p, limit, err := backend.releases(ctx, owner, repo, pattern, pageSize, cursor)
+		limit.Cost += currLimit.Cost
+		currLimit = limit
+		if err != nil {
+			return currLimit, err
+		}
+		if remaining := s.depth - inspected; len(p.Releases) > remaining {
+			p.Releases = p.Releases[:remaining]
+		}
+		inspected += len(p.Releases)
+
+		if !fn(p) || p.Next == "" {
+			return currLimit, nil
+		}
+		if inspected >= s.depth {
+			s.logger.Info("search depth reached", "owner", owner, "repo", repo, "depth", s.depth)
+			return currLimit, nil
+		}
+		if currLimit.Cost >= s.costBudget || (currLimit.Limit > 0 && currLimit.Remaining < reservedPoints) {
+			s.logger.Info("cost budget spent", "owner", owner, "repo", repo, "cost", currLimit.Cost, "budget", s.costBudget, "remaining", currLimit.Remaining)
+			return currLimit, nil
+		}
+		cursor = p.Next
+	}
+}
+
+// fetchLatestRelease returns the newest release in `channel` which contains a matching asset.
+func (s releaseSearch) fetchLatestRelease(ctx context.Context, backend releaseBackend, owner, repo string, channel releaseChannel, pattern assetPattern) (resolvedRelease, rateLimit, error) {
+	var release resolvedRelease
+	found := false
+	currLimit, err := s.pageReleases(ctx, backend, owner, repo, pattern, latestPageSize, func(p releasePage) bool {
+		releases := p.Releases
+		if channel == channelStable && p.Latest != nil {
+			releases = append([]releaseNode{*p.Latest}, releases...)
+		}
+
+		for _, node := range releases {
+			if !channel.contains(node) {
+				continue
+			}
+			found = true
+			if assets := pattern.match(node.TagName, node); len(assets) > 0 {
+				release = resolvedRelease{TagName: node.TagName, Assets: assets}
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return release, currLimit, err
+	}
+
+	if len(release.Assets) > 0 {
+		return release, currLimit, nil
+	}
+	if !found {
+		return release, currLimit, errReleaseNotFound
+	}
+	return release, currLimit, errAssetNotFound
+}
+
+func (s releaseSearch) fetchSpecificTag(ctx context.Context, backend releaseBackend, owner, repo, tag string, pattern assetPattern) (resolvedRelease, rateLimit, error) {
+	release, currLimit, err := backend.releaseByTag(ctx, owner, repo, tag, pattern)
+	if err != nil {
+		return resolvedRelease{}, currLimit, err
+	}
+	if release == nil {
+		return resolvedRelease{}, currLimit, errReleaseNotFound
+	}
+	assets := pattern.match(tag, *release)
+
+	return resolvedRelease{TagName: tag, Assets: assets}, currLimit, nil
+}
+
+var (
+	// rangeOperators matches the operators of a semver range, e.g. `^1.4`, `>=1.2 <2` or `1.2 - 1.4`.
+	rangeOperators = regexp.MustCompile(`[\^~<>,|]|\s-\s`)
+	// wildcardVersion matches versions with wildcards in place of their minor or patch version, e.g. `1.x` or `v1.2.*`.
+	wildcardVersion = regexp.MustCompile(`^v?\d+(\.\d+)*(\.[xX*])+$`)
+)
+
+// gitRefForbidden are the characters git does not allow in the name of a tag, see git-check-ref-format(1).
+const gitRefForbidden = " ~^:?*[\\"
+
+// versionConstraint returns the parsed constraint if `tag` is a semver range (e.g. `^1.4`, `~2.3`, `>=1.2 <2` or `1.x`).
+// Only tags containing range operators or wildcards in place of a version number are considered a range, others
+// such as `1.2`, `=1.2` or `x` are looked up as a literal tag instead.
+func versionConstraint(tag string) (*semver.Constraints, bool) {
+	if !rangeOperators.MatchString(tag) && !wildcardVersion.MatchString(tag) {
+		return nil, false
+	}
+	constraint, err := semver.NewConstraint(tag)
+	if err != nil {
+		return nil, false
+	}
+	return constraint, true
+}
+
+// fetchVersionRange pages through the releases and returns the highest version matching `constraint`
+// which contains the asset. Tags which are not valid semantic versions are ignored.
+func (s releaseSearch) fetchVersionRange(ctx context.Context, backend releaseBackend, owner, repo string, constraint *semver.Constraints, pattern assetPattern) (resolvedRelease, rateLimit, error) {
+	var best *semver.Version
+	var bestRelease resolvedRelease
+	matched := false
+	currLimit, err := s.pageReleases(ctx, backend, owner, repo, pattern, rangePageSize, func(p releasePage) bool {
+		for _, node := range p.Releases {
+			if node.IsDraft {
+				continue
+			}
+			v, err := semver.NewVersion(node.TagName)
+			if err != nil || !constraint.Check(v) {
+				continue
+			}
+			matched = true
+			assets := pattern.match(node.TagName, node)
+			if len(assets) == 0 {
+				continue
+			}
+			if best == nil || v.GreaterThan(best) {
+				best = v
+				bestRelease = resolvedRelease{TagName: node.TagName, Assets: assets}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return bestRelease, currLimit, err
+	}
+
+	if !matched {
+		return bestRelease, currLimit, errReleaseNotFound
+	}
+	if best == nil {
+		return bestRelease, currLimit, errAssetNotFound
+	}
+	return bestRelease, currLimit, nil
+}
+
+// resolve decides based on the supplied `tag` how the release is looked up.
+func (s releaseSearch) resolve(ctx context.Context, backend releaseBackend, owner, repo, tag string, pattern assetPattern) (resolvedRelease, rateLimit, error) {
+	if channel, ok := latestChannels[tag]; ok {
+		return s.fetchLatestRelease(ctx, backend, owner, repo, channel, pattern)
+	}
+	// a range which is a valid tag name, e.g. `1.x`, is only resolved as range if there is no such tag.
+	constraint, ok := versionConstraint(tag)
+	if ok && strings.ContainsAny(tag, gitRefForbidden) {
+		return s.fetchVersionRange(ctx, backend, owner, repo, constraint, pattern)
+	}
+	release, currLimit, err := s.fetchSpecificTag(ctx, backend, owner, repo, tag, pattern)
+	if !ok || err != errReleaseNotFound {
+		return release, currLimit, err
+	}
+
+	release, rangeLimit, err := s.fetchVersionRange(ctx, backend, owner, repo, constraint, pattern)
+	rangeLimit.Cost += currLimit.Cost
+	return release, rangeLimit, err
+}
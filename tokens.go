@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitedError is returned if the rate limit of all tokens is exhausted.
+type rateLimitedError struct {
+	ResetAt time.Time
+}
+
+func (e rateLimitedError) Error() string {
+	return fmt.Sprintf("github: rate limit exhausted until %s", e.ResetAt.Format(time.RFC3339))
+}
+
+// tokenLimit is the last known rate limit of a token for one of GitHub's rate limit resources (e.g. `core` or `graphql`).
+type tokenLimit struct {
+	remaining int
+	resetAt   time.Time
+}
+
+type pooledToken struct {
+	value  string
+	limits map[string]tokenLimit
+}
+
+// remaining returns the remaining points of the token. Tokens without known rate limit or whose limit
+// has been reset in the meantime are preferred.
+func (t *pooledToken) remaining(resource string, now time.Time) (int, time.Time) {
+	limit, ok := t.limits[resource]
+	if !ok || now.After(limit.resetAt) {
+		return math.MaxInt32, time.Time{}
+	}
+	return limit.remaining, limit.resetAt
+}
+
+// TokenPool is an `http.RoundTripper` authenticating every request with the token having the most remaining
+// rate limit points. Tokens without any points left are parked until their limit is reset.
+type TokenPool struct {
+	base   http.RoundTripper
+	l      sync.Mutex
+	tokens []*pooledToken
+}
+
+// NewTokenPool creates a pool of the personal access tokens `tokens` sending requests using `base`.
+func NewTokenPool(tokens []string, base http.RoundTripper) *TokenPool {
+	tp := TokenPool{base: base}
+	for _, token := range tokens {
+		tp.tokens = append(tp.tokens, &pooledToken{value: token, limits: make(map[string]tokenLimit)})
+	}
+	return &tp
+}
+
+// rateLimitResource guesses the rate limit resource of a request, GitHub's response contains the actual one.
+func rateLimitResource(r *http.Request) string {
+	if strings.HasSuffix(r.URL.Path, "/graphql") || r.Method == http.MethodPost {
+		return "graphql"
+	}
+	return "core"
+}
+
+func (tp *TokenPool) acquire(resource string) (*pooledToken, error) {
+	tp.l.Lock()
+	defer tp.l.Unlock()
+
+	now := time.Now()
+	var best *pooledToken
+	var bestRemaining int
+	var earliestReset time.Time
+	for _, token := range tp.tokens {
+		remaining, resetAt := token.remaining(resource, now)
+		if remaining <= 0 {
+			if earliestReset.IsZero() || resetAt.Before(earliestReset) {
+				earliestReset = resetAt
+			}
+			continue
+		}
+		if best == nil || remaining > bestRemaining {
+			best = token
+			bestRemaining = remaining
+		}
+	}
+	if best == nil {
+		return nil, rateLimitedError{ResetAt: earliestReset}
+	}
+	return best, nil
+}
+
+// observe updates the rate limit of `token` using the response headers.
+// Secondary rate limits are indicated using `Retry-After` and park the token as well.
+func (tp *TokenPool) observe(token *pooledToken, resource string, resp *http.Response) {
+	h := resp.Header
+	if r := h.Get("X-RateLimit-Resource"); r != "" {
+		resource = r
+	}
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, _ := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	limit := tokenLimit{remaining: remaining, resetAt: time.Unix(reset, 0)}
+
+	if retryAfter, err := strconv.Atoi(h.Get("Retry-After")); err == nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) {
+		limit = tokenLimit{remaining: 0, resetAt: time.Now().Add(time.Duration(retryAfter) * time.Second)}
+	}
+
+	tp.l.Lock()
+	token.limits[resource] = limit
+	tp.l.Unlock()
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (tp *TokenPool) RoundTrip(r *http.Request) (*http.Response, error) {
+	resource := rateLimitResource(r)
+	token, err := tp.acquire(resource)
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+
+	req := r.Clone(r.Context())
+	req.Header.Set("Authorization", "bearer "+token.value)
+	resp, err := tp.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	tp.observe(token, resource, resp)
+	return resp, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenPool_RoundTrip(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour)
+	remaining := map[string]int{"bearer a": 10, "bearer b": 20}
+	var used []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		used = append(used, auth)
+		remaining[auth]--
+		w.Header().Set("X-RateLimit-Resource", "graphql")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining[auth]))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+	})
+	httpServer, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	pool := NewTokenPool([]string{"a", "b"}, http.DefaultTransport)
+	client := &http.Client{Transport: pool}
+	post := func() error {
+		resp, err := client.Post(httpServer.URL+"/graphql", "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// both tokens are unknown at first, afterwards the one with the most remaining points is used.
+	for i := 0; i < 3; i++ {
+		if err := post(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if used[2] != "bearer b" {
+		t.Errorf("expected token with most remaining points to be used, got '%s'", used[2])
+	}
+
+	remaining["bearer a"] = 1
+	remaining["bearer b"] = 1
+	for i := 0; i < 2; i++ {
+		if err := post(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err := post()
+	var limited rateLimitedError
+	if !errors.As(err, &limited) || limited.ResetAt.Unix() != resetAt.Unix() {
+		t.Errorf("expected rateLimitedError resetting at %s, got '%v'", resetAt, err)
+	}
+	if ghErr := transportError(err); ghErr.Type != TypeRateLimited {
+		t.Errorf("expected error to be classified as rate limited, got '%v'", ghErr.Type)
+	}
+}